@@ -0,0 +1,60 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// One change for UpdateAliases.
+type AliasAction struct {
+	// "add" or "remove".
+	Action string
+	Index  string
+	Alias  string
+	// For "add", whether writes to the alias go to this index.
+	IsWriteIndex *bool
+}
+
+func (a AliasAction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		a.Action: struct {
+			Index        string `json:"index"`
+			Alias        string `json:"alias"`
+			IsWriteIndex *bool  `json:"is_write_index,omitempty"`
+		}{a.Index, a.Alias, a.IsWriteIndex},
+	})
+}
+
+// Apply several alias changes atomically.
+//
+// Either every action takes effect or none does, so an alias can be
+// moved from one index to another with no moment where it points at
+// neither.
+func (es *ElasticSearch) UpdateAliases(actions []AliasAction) error {
+	_, err := es.call("POST", es.url("_aliases").String(),
+		map[string][]AliasAction{"actions": actions}, nil)
+	return err
+}
+
+// Fetch an alias's definition on each index it points to, keyed by
+// index name.
+func (es *ElasticSearch) GetAlias(name string) (json.RawMessage, error) {
+	var rv json.RawMessage
+	_, err := es.call("GET", es.url("_alias", name).String(), nil, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Check whether an alias exists.
+func (es *ElasticSearch) AliasExists(name string) (bool, error) {
+	status, err := es.call("HEAD", es.url("_alias", name).String(), nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
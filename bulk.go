@@ -2,30 +2,144 @@ package elasticsearch
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	BulkItemsFailed = errors.New("One or more bulk items failed")
+	BulkClosed      = errors.New("Bulk updater has been shut down")
+	// Wrapped by the error for an instruction that alone exceeds
+	// BulkOptions.MaxBytes.
+	BulkDocumentTooLarge = errors.New("Bulk document too large")
+	// Returned by Update when BulkOptions.FailWhenFull is set and
+	// the queue is full.
+	BulkBufferFull = errors.New("Bulk update queue is full")
 )
 
 // Abstract bulk update instruction.
 type Instruction interface {
-	writeTo(w io.Writer) error
+	// Check for missing fields the server would reject.
+	validate() error
+	writeTo(e encoder) error
+}
+
+// Implemented by instructions whose routing and type a writer can
+// fill in.
+type defaultable interface {
+	// Copy of the instruction with d applied.
+	withDefaults(d instructionDefaults) Instruction
+}
+
+// Implemented by instructions that carry a Ref.
+type referenced interface {
+	ref() interface{}
+}
+
+// A writer's defaults for fields an instruction leaves empty.
+type instructionDefaults struct {
+	// Routing by index.
+	routing map[string]string
+	doctype string
+	// The index in the bulk URL, which needn't be repeated.
+	index string
+}
+
+func (d instructionDefaults) apply(index, routing, doctype string) (string, string, string) {
+	if routing == "" {
+		routing = d.routing[index]
+	}
+	if doctype == "" {
+		doctype = d.doctype
+	}
+	if index == d.index {
+		index = ""
+	}
+	return index, routing, doctype
+}
+
+// Writes each value as one line of the bulk body.  *json.Encoder
+// satisfies this.
+type encoder interface {
+	Encode(v interface{}) error
+}
+
+// Encoder using a BulkOptions.Marshaler.
+type marshalEncoder struct {
+	w       io.Writer
+	marshal func(v interface{}) ([]byte, error)
+}
+
+func (e *marshalEncoder) Encode(v interface{}) error {
+	data, err := e.marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(bytes.TrimRight(data, "\n"), '\n')
+	_, err = e.w.Write(data)
+	return err
+}
+
+func validateVersionType(action, versionType string) error {
+	switch versionType {
+	case "", "internal", "external", "external_gte":
+		return nil
+	}
+	return fmt.Errorf("%s instruction has unknown version type %q",
+		action, versionType)
+}
+
+func validateTarget(action, index, id string, needId bool) error {
+	if index == "" {
+		return fmt.Errorf("%s instruction is missing an index", action)
+	}
+	if needId && id == "" {
+		return fmt.Errorf("%s instruction for index %q is missing an id",
+			action, index)
+	}
+	return nil
 }
 
 // Instruction to update an index entry.
 type UpdateInstruction struct {
-	Id      string                 `json:"_id"`
-	Index   string                 `json:"_index"`
-	Type    string                 `json:"_type"`
-	Routing string                 `json:"_routing,omitempty"`
-	Body    map[string]interface{} `json:"-"`
+	Id       string `json:"_id"`
+	Index    string `json:"_index,omitempty"`
+	Type     string `json:"_type,omitempty"`
+	Routing  string `json:"_routing,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	// Only apply if the document's sequence number and primary
+	// term still match; otherwise the item fails with a conflict.
+	IfSeqNo       *int64                 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64                 `json:"if_primary_term,omitempty"`
+	Body          map[string]interface{} `json:"-"`
+	// Opaque value echoed back in the item's BulkItemResult.Ref.
+	// It isn't sent to the server.
+	Ref interface{} `json:"-"`
 }
 
-func (ui *UpdateInstruction) writeTo(w io.Writer) error {
-	e := json.NewEncoder(w)
+func (ui *UpdateInstruction) validate() error {
+	return validateTarget("index", ui.Index, ui.Id, true)
+}
+
+func (ui *UpdateInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *ui
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (ui *UpdateInstruction) ref() interface{} {
+	return ui.Ref
+}
+
+func (ui *UpdateInstruction) writeTo(e encoder) error {
 	err := e.Encode(map[string]interface{}{
 		"index": ui,
 	})
@@ -36,109 +150,1710 @@ func (ui *UpdateInstruction) writeTo(w io.Writer) error {
 	return err
 }
 
+// Instruction to add a document to an index.
+//
+// Unlike UpdateInstruction, the Id is optional.  An empty Id omits
+// _id from the action entirely, which causes the server to generate
+// one.
+//
+// VersionType may be "internal", "external" or "external_gte".  With
+// "external_gte" a Version equal to the stored one is accepted, so the
+// same event can be redelivered without a conflict.
+type IndexInstruction struct {
+	Id            string                 `json:"_id,omitempty"`
+	Index         string                 `json:"_index,omitempty"`
+	Type          string                 `json:"_type,omitempty"`
+	Routing       string                 `json:"_routing,omitempty"`
+	Pipeline      string                 `json:"pipeline,omitempty"`
+	Version       *int64                 `json:"version,omitempty"`
+	VersionType   string                 `json:"version_type,omitempty"`
+	IfSeqNo       *int64                 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64                 `json:"if_primary_term,omitempty"`
+	Body          map[string]interface{} `json:"-"`
+	// Fail the item unless Index names an alias, rather than
+	// creating a concrete index by that name.
+	RequireAlias bool `json:"require_alias,omitempty"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (ii *IndexInstruction) validate() error {
+	if err := validateVersionType("index", ii.VersionType); err != nil {
+		return err
+	}
+	return validateTarget("index", ii.Index, ii.Id, false)
+}
+
+func (ii *IndexInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *ii
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (ii *IndexInstruction) ref() interface{} {
+	return ii.Ref
+}
+
+func (ii *IndexInstruction) writeTo(e encoder) error {
+	err := e.Encode(map[string]interface{}{
+		"index": ii,
+	})
+	if err != nil {
+		return err
+	}
+	err = e.Encode(ii.Body)
+	return err
+}
+
+// Instruction to index a document at an externally supplied version.
+//
+// The document is only written if Version is higher than the one
+// already stored, so replaying an old event is harmless.  A stale
+// replay comes back with Skipped set on its BulkItemResult, and
+// doesn't count as a failure.
+type ReplayInstruction struct {
+	Id       string                 `json:"_id"`
+	Index    string                 `json:"_index,omitempty"`
+	Type     string                 `json:"_type,omitempty"`
+	Routing  string                 `json:"_routing,omitempty"`
+	Pipeline string                 `json:"pipeline,omitempty"`
+	Version  int64                  `json:"version"`
+	Body     map[string]interface{} `json:"-"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (ri *ReplayInstruction) validate() error {
+	return validateTarget("index", ri.Index, ri.Id, true)
+}
+
+func (ri *ReplayInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *ri
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (ri *ReplayInstruction) ref() interface{} {
+	return ri.Ref
+}
+
+func (ri *ReplayInstruction) writeTo(e encoder) error {
+	type action ReplayInstruction
+	err := e.Encode(map[string]interface{}{
+		"index": struct {
+			*action
+			VersionType string `json:"version_type"`
+		}{(*action)(ri), "external"},
+	})
+	if err != nil {
+		return err
+	}
+	err = e.Encode(ri.Body)
+	return err
+}
+
+// Instruction to add a document only if it doesn't already exist.
+//
+// If a document with the same Id is already present, the
+// corresponding BulkItemResult reports a conflict.
+type CreateInstruction struct {
+	Id            string                 `json:"_id"`
+	Index         string                 `json:"_index,omitempty"`
+	Type          string                 `json:"_type,omitempty"`
+	Routing       string                 `json:"_routing,omitempty"`
+	IfSeqNo       *int64                 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64                 `json:"if_primary_term,omitempty"`
+	Body          map[string]interface{} `json:"-"`
+	// As for IndexInstruction, except that the server only
+	// allows "internal" versioning for creates.
+	Version      *int64 `json:"version,omitempty"`
+	VersionType  string `json:"version_type,omitempty"`
+	RequireAlias bool   `json:"require_alias,omitempty"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (ci *CreateInstruction) validate() error {
+	if ci.VersionType != "" && ci.VersionType != "internal" {
+		return fmt.Errorf("create instruction only supports internal versioning, not %q",
+			ci.VersionType)
+	}
+	return validateTarget("create", ci.Index, ci.Id, true)
+}
+
+func (ci *CreateInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *ci
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (ci *CreateInstruction) ref() interface{} {
+	return ci.Ref
+}
+
+func (ci *CreateInstruction) writeTo(e encoder) error {
+	err := e.Encode(map[string]interface{}{
+		"create": ci,
+	})
+	if err != nil {
+		return err
+	}
+	err = e.Encode(ci.Body)
+	return err
+}
+
+// A script to run against a document on the server.
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Instruction to modify an existing document with a script.
+//
+// This emits a real update action, so the document doesn't need to
+// be fetched first.  If Upsert is set, it is indexed as-is when the
+// document doesn't exist yet.
+type ScriptUpdateInstruction struct {
+	Id            string                 `json:"_id"`
+	Index         string                 `json:"_index,omitempty"`
+	Type          string                 `json:"_type,omitempty"`
+	Routing       string                 `json:"_routing,omitempty"`
+	IfSeqNo       *int64                 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64                 `json:"if_primary_term,omitempty"`
+	Script        Script                 `json:"-"`
+	Upsert        map[string]interface{} `json:"-"`
+	DocAsUpsert   bool                   `json:"-"`
+	// If set, return the updated document in the item's Source.
+	// Either true or a list of field names.
+	Source interface{} `json:"-"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (si *ScriptUpdateInstruction) validate() error {
+	return validateTarget("update", si.Index, si.Id, true)
+}
+
+func (si *ScriptUpdateInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *si
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (si *ScriptUpdateInstruction) ref() interface{} {
+	return si.Ref
+}
+
+func (si *ScriptUpdateInstruction) writeTo(e encoder) error {
+	err := e.Encode(map[string]interface{}{
+		"update": si,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"script": si.Script,
+	}
+	if si.Upsert != nil {
+		body["upsert"] = si.Upsert
+	}
+	if si.DocAsUpsert {
+		body["doc_as_upsert"] = true
+	}
+	if si.Source != nil {
+		body["_source"] = si.Source
+	}
+	err = e.Encode(body)
+	return err
+}
+
+// Instruction to change some fields of an existing document.
+//
+// Fields not mentioned in Doc are left as they are, unlike
+// UpdateInstruction, which replaces the whole document.  With
+// DocAsUpsert, Doc is indexed as a new document if none exists.
+type PartialUpdateInstruction struct {
+	Id            string                 `json:"_id"`
+	Index         string                 `json:"_index,omitempty"`
+	Type          string                 `json:"_type,omitempty"`
+	Routing       string                 `json:"_routing,omitempty"`
+	IfSeqNo       *int64                 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64                 `json:"if_primary_term,omitempty"`
+	Doc           map[string]interface{} `json:"-"`
+	DocAsUpsert   bool                   `json:"-"`
+	// As for ScriptUpdateInstruction.
+	Source interface{} `json:"-"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (pi *PartialUpdateInstruction) validate() error {
+	return validateTarget("update", pi.Index, pi.Id, true)
+}
+
+func (pi *PartialUpdateInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *pi
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (pi *PartialUpdateInstruction) ref() interface{} {
+	return pi.Ref
+}
+
+func (pi *PartialUpdateInstruction) writeTo(e encoder) error {
+	err := e.Encode(map[string]interface{}{
+		"update": pi,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"doc": pi.Doc,
+	}
+	if pi.DocAsUpsert {
+		body["doc_as_upsert"] = true
+	}
+	if pi.Source != nil {
+		body["_source"] = pi.Source
+	}
+	err = e.Encode(body)
+	return err
+}
+
 // Instruction to delete an item from an index.
 type DeleteInstruction struct {
 	Id      string `json:"_id"`
-	Index   string `json:"_index"`
-	Type    string `json:"_type"`
+	Index   string `json:"_index,omitempty"`
+	Type    string `json:"_type,omitempty"`
 	Routing string `json:"_routing,omitempty"`
+	// Only delete if the document is still at this version, or
+	// sequence number and primary term; otherwise the item fails
+	// with a conflict.
+	Version       *int64 `json:"version,omitempty"`
+	IfSeqNo       *int64 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64 `json:"if_primary_term,omitempty"`
+	// As for UpdateInstruction.
+	Ref interface{} `json:"-"`
+}
+
+func (di *DeleteInstruction) validate() error {
+	return validateTarget("delete", di.Index, di.Id, true)
+}
+
+func (di *DeleteInstruction) withDefaults(d instructionDefaults) Instruction {
+	c := *di
+	c.Index, c.Routing, c.Type = d.apply(c.Index, c.Routing, c.Type)
+	return &c
+}
+
+func (di *DeleteInstruction) ref() interface{} {
+	return di.Ref
 }
 
-func (di *DeleteInstruction) writeTo(w io.Writer) error {
-	e := json.NewEncoder(w)
+func (di *DeleteInstruction) writeTo(e encoder) error {
 	return e.Encode(map[string]interface{}{
 		"delete": di,
 	})
 }
 
+// Error detail for a single failed bulk item.
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// How many failures a BulkError describes in its message.
+const bulkErrorMaxItems = 5
+
+// Error for a batch in which some items failed, returned instead of
+// BulkItemsFailed when BulkOptions.FailOnItemError is set.
+//
+// errors.Is(err, BulkItemsFailed) still holds for a BulkError.
+type BulkError struct {
+	// Every item that failed.
+	Items []BulkItemResult
+}
+
+func (e *BulkError) Error() string {
+	msg := fmt.Sprintf("%d bulk items failed", len(e.Items))
+	for i, item := range e.Items {
+		if i == bulkErrorMaxItems {
+			msg += fmt.Sprintf("; and %d more", len(e.Items)-i)
+			break
+		}
+		msg += fmt.Sprintf("; %s %s/%s: %d", item.Action, item.Index, item.Id,
+			item.Status)
+		if item.Error != nil {
+			msg += fmt.Sprintf(" %s: %s", item.Error.Type, item.Error.Reason)
+		}
+	}
+	return msg
+}
+
+func (e *BulkError) Unwrap() error {
+	return BulkItemsFailed
+}
+
+// Result of a single instruction within a bulk request.
+type BulkItemResult struct {
+	// The bulk action this result is for (e.g. "index" or "delete").
+	Action string `json:"-"`
+	Index  string `json:"_index"`
+	Type   string `json:"_type"`
+	// The document's ID, including one the server generated for an
+	// IndexInstruction without an Id.
+	Id     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  *BulkItemError `json:"error,omitempty"`
+	// The updated document, for update instructions that asked
+	// for it.
+	Source json.RawMessage `json:"-"`
+	// Set for a ReplayInstruction the server ignored because the
+	// stored document was already at the same or a newer version.
+	Skipped bool `json:"-"`
+	// The instruction's Ref.
+	Ref interface{} `json:"-"`
+}
+
+func (r *BulkItemResult) UnmarshalJSON(data []byte) error {
+	// Each item is an object keyed by the action name.
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	type plain BulkItemResult
+	for action, raw := range wrapper {
+		p := (*plain)(r)
+		if err := json.Unmarshal(raw, p); err != nil {
+			return err
+		}
+		r.Action = action
+
+		var get struct {
+			Get struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"get"`
+		}
+		if err := json.Unmarshal(raw, &get); err != nil {
+			return err
+		}
+		r.Source = get.Get.Source
+	}
+	return nil
+}
+
+// Failed reports whether this item was rejected by the server.
+func (r *BulkItemResult) Failed() bool {
+	if r.Skipped {
+		return false
+	}
+	return r.Error != nil || r.Status > 299
+}
+
+// Conflict reports whether this item was rejected because of a
+// version conflict, e.g. a create for a document that already exists.
+func (r *BulkItemResult) Conflict() bool {
+	return r.Status == http.StatusConflict
+}
+
+func (r *BulkItemResult) errorType() string {
+	if r.Error == nil {
+		return ""
+	}
+	return r.Error.Type
+}
+
+// Parsed response from a bulk request.
+//
+// Items are in the same order as the instructions in the batch.
+// When retries are enabled, Items holds the final outcome of each
+// instruction and Retries counts the resubmissions made.
+type BulkResponse struct {
+	Took    int              `json:"took"`
+	Errors  bool             `json:"errors"`
+	Items   []BulkItemResult `json:"items"`
+	Retries int              `json:"-"`
+	// In DryRun mode, the request body that would have been sent.
+	Body []byte `json:"-"`
+}
+
+// IDs returns the document ID of each item, in batch order.  Items
+// that failed before an ID was assigned give an empty string.
+func (br *BulkResponse) IDs() []string {
+	rv := make([]string, len(br.Items))
+	for i, item := range br.Items {
+		rv[i] = item.Id
+	}
+	return rv
+}
+
+// Failed returns the items that were rejected by the server.
+func (br *BulkResponse) Failed() []BulkItemResult {
+	var rv []BulkItemResult
+	for _, item := range br.Items {
+		if item.Failed() {
+			rv = append(rv, item)
+		}
+	}
+	return rv
+}
+
+// A request from SendBatch for the bulk goroutine to hand over the
+// current batch.
+type batchCall struct {
+	ctx   context.Context
+	reply chan bulkRequest
+}
+
+// A prepared batch handed from the bulk goroutine to SendBatch.
+type bulkRequest struct {
+	req *http.Request
+	// The encoded batch and the offset at which each instruction
+	// starts, so failed items can be re-sent individually.
+	body    []byte
+	offsets []int
+	// Positions of the ReplayInstructions in the batch.
+	replays []int
+	// The instructions themselves, if OnItemResult is set.
+	instructions []Instruction
+	// Each instruction's Ref.
+	refs []interface{}
+	// The buffer holding body, to recycle once it has been sent.
+	buf *bytes.Buffer
+	// First error encountered while building this batch.
+	err error
+}
+
 type bulkWriter struct {
-	es     *ElasticSearch
-	update chan Instruction
-	reqch  chan chan *http.Request
-	quit   chan bool
-	w      *bytes.Buffer
+	es      *ElasticSearch
+	update  chan Instruction
+	reqch   chan batchCall
+	peekch  chan chan []byte
+	sizech  chan chan pendingSize
+	resetch chan chan struct{}
+	// Batches from SendBatch that provably weren't applied, to be
+	// put back at the front of the buffer.
+	restore chan bulkRequest
+	quit    chan chan error
+	// Closed when the bulk goroutine exits.
+	done chan struct{}
+	opts BulkOptions
+	w    *bytes.Buffer
+	// Offset in w at which each buffered instruction starts.
+	offsets []int
+	// Positions of the buffered ReplayInstructions.
+	replays []int
+	// The buffered instructions, if OnItemResult is set.
+	instructions []Instruction
+	// Each buffered instruction's Ref.
+	refs []interface{}
+	// First error encountered since the last batch was issued.
+	// Only touched by the bulk goroutine.
+	err error
+	// When the bulk goroutine last sent a batch.
+	lastFlush time.Time
+
+	statsMu sync.Mutex
+	stats   BulkStats
+
+	// If set, automatic flushes are sent here rather than inline.
+	dispatch chan<- bulkRequest
+
+	// Per-index default routings.
+	routingMu sync.Mutex
+	routing   map[string]string
+
+	// Requests to change opts.Index.
+	indexch chan indexChange
+
+	// Held for reading while sending on update, so that once Quit
+	// sets closed nothing more can be queued.  SetDefaultIndex
+	// holds it so no instruction is defaulted against the old
+	// index but queued after the change.
+	closeMu sync.RWMutex
+	closed  bool
+
+	quitOnce sync.Once
+	quitErr  error
+
+	// Serializes writes to opts.Output.
+	outputMu sync.Mutex
+}
+
+// Running totals for a bulk updater.
+type BulkStats struct {
+	// Batches sent, whether by SendBatch or automatically.
+	FlushedBatches int64
+	// Instructions in those batches.
+	FlushedDocs int64
+	// Instructions that ultimately failed, including every one in
+	// a batch that got no response at all.
+	FailedDocs int64
+	// Request body bytes written, including retries.
+	BytesSent int64
+	// Time spent sending batches, including retries.
+	TotalFlushDuration time.Duration
+	// Instructions queued but not yet added to a batch.
+	QueueDepth int
 }
 
 // Interface for writing bulk data into elasticsearch.
 type BulkUpdater interface {
 	// Update the index with a new record (or delete a record).
-	Update(ui Instruction)
+	//
+	// An instruction missing a required index or id is rejected
+	// here rather than being sent.  Update is safe to call from
+	// multiple goroutines.  After Quit it returns BulkClosed.
+	//
+	// If the queue is full Update blocks, or returns BulkBufferFull
+	// when FailWhenFull is set.
+	Update(ui Instruction) error
 	// Send the current batch.
-	SendBatch() error
-	// Shut down this bulk interface
-	Quit()
+	//
+	// The parsed response is returned whenever the server replied.
+	// If any individual item failed, BulkItemsFailed (or a
+	// BulkError, with FailOnItemError) is returned along with the
+	// response so the failures can be inspected.
+	//
+	// Instructions that could not be serialized are dropped from
+	// the batch, and the first such error is returned here.  So is
+	// the first error from any automatic flush since the last call.
+	//
+	// If MaxRetries is set, items rejected as RetryableFunc allows
+	// are re-sent before this returns.  If nothing is pending, nothing
+	// is sent and the response is nil.
+	//
+	// If the request provably never reached the server, because it
+	// couldn't connect, ctx was already done, or the server turned it
+	// away with 429 or 503, the batch is put back in front of any
+	// newer instructions so a later call can send it again.  After
+	// other failures, such as a timeout, the server may have applied
+	// it, so it's dropped and re-sending is left to the caller.
+	// Automatic flushes never keep batches.
+	SendBatch() (*BulkResponse, error)
+	// Send the current batch, aborting if ctx is done first.
+	SendBatchContext(ctx context.Context) (*BulkResponse, error)
+	// As SendBatchContext, but also report how many instructions
+	// the batch held.
+	Flush(ctx context.Context) (int, *BulkResponse, error)
+	// Copy of the NDJSON body the current batch would send.
+	Preview() ([]byte, error)
+	// Discard the current batch, including anything still queued,
+	// without sending it.  A batch already being sent is
+	// unaffected.
+	Reset() error
+	// Size in bytes of the current batch, or 0 after Quit.
+	PendingBytes() int
+	// Number of instructions in the current batch, or 0 after Quit.
+	PendingCount() int
+	// Change BulkOptions.Index.  The pending batch is sent first, so
+	// instructions already given to Update go where they would
+	// have before.
+	SetDefaultIndex(index string) error
+	// Route instructions for index that don't set their own Routing
+	// by routing, from the next Update on.  An empty routing removes
+	// the default.  This takes precedence over BulkOptions.Routing.
+	SetDefaultRouting(index, routing string)
+	// Snapshot of this updater's statistics.  Safe to call at any
+	// time, including after Quit.
+	Stats() BulkStats
+	// Shut down this bulk interface.
+	//
+	// Any pending batch is sent first.  The error reports whether
+	// that final send (or an earlier automatic flush) failed.
+	//
+	// Quit may be called more than once; later calls wait for the
+	// first to finish and return the same error.  After Quit,
+	// Update, SendBatch and the other batch methods return
+	// BulkClosed.
+	Quit() error
+}
+
+func (b *bulkWriter) Update(ui Instruction) error {
+	if err := ui.validate(); err != nil {
+		return err
+	}
+
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+	if b.closed {
+		return BulkClosed
+	}
+
+	if di, ok := ui.(defaultable); ok {
+		b.routingMu.Lock()
+		if len(b.routing) > 0 || b.opts.DefaultType != "" || b.opts.Index != "" {
+			ui = di.withDefaults(instructionDefaults{
+				b.routing, b.opts.DefaultType, b.opts.Index,
+			})
+		}
+		b.routingMu.Unlock()
+	}
+
+	if b.opts.FailWhenFull {
+		select {
+		case b.update <- ui:
+			return nil
+		case <-b.done:
+			return BulkClosed
+		default:
+			return BulkBufferFull
+		}
+	}
+	select {
+	case b.update <- ui:
+		return nil
+	case <-b.done:
+		return BulkClosed
+	}
+}
+
+func (b *bulkWriter) SendBatch() (*BulkResponse, error) {
+	return b.SendBatchContext(context.Background())
+}
+
+func (b *bulkWriter) SendBatchContext(ctx context.Context) (*BulkResponse, error) {
+	_, rv, err := b.Flush(ctx)
+	return rv, err
+}
+
+func (b *bulkWriter) Flush(ctx context.Context) (int, *BulkResponse, error) {
+	reqch := make(chan bulkRequest)
+	select {
+	case b.reqch <- batchCall{ctx, reqch}:
+	case <-b.done:
+		return 0, nil, BulkClosed
+	case <-ctx.Done():
+		return 0, nil, fmt.Errorf("Bulk request aborted: %w", ctx.Err())
+	}
+	br := <-reqch
+	rv, untouched, err := b.complete(br, true)
+	if untouched {
+		// Nothing was applied, so keep the batch for another try.
+		select {
+		case b.restore <- br:
+		case <-b.done:
+		}
+	}
+	return len(br.offsets), rv, err
 }
 
-func (b *bulkWriter) Update(ui Instruction) {
-	b.update <- ui
+// Put a batch that wasn't sent back in front of the pending one.
+func (b *bulkWriter) unshift(br bulkRequest) {
+	n := len(br.body)
+	offsets := append([]int(nil), br.offsets...)
+	for _, off := range b.offsets {
+		offsets = append(offsets, off+n)
+	}
+	replays := append([]int(nil), br.replays...)
+	for _, i := range b.replays {
+		replays = append(replays, i+len(br.offsets))
+	}
+
+	b.w = bytes.NewBuffer(append(br.body[:n:n], b.w.Bytes()...))
+	b.offsets = offsets
+	b.replays = replays
+	if b.opts.OnItemResult != nil {
+		b.instructions = append(br.instructions[:len(br.instructions):len(br.instructions)],
+			b.instructions...)
+	}
+	b.refs = append(br.refs[:len(br.refs):len(br.refs)], b.refs...)
 }
 
-func (b *bulkWriter) SendBatch() error {
-	reqch := make(chan *http.Request)
-	b.reqch <- reqch
-	req := <-reqch
+// Send a prepared batch, reporting any error held from building it
+// if the send itself succeeded.
+//
+// With keep set, untouched reports that the batch provably never
+// reached the server or Output, so the caller should restore it and
+// try again.  Its buffer is only released otherwise.
+func (b *bulkWriter) complete(br bulkRequest, keep bool) (rv *BulkResponse, untouched bool, err error) {
+	if br.req == nil {
+		return nil, false, br.err
+	}
+
+	if b.opts.DryRun {
+		return &BulkResponse{Body: br.body}, false, br.err
+	}
+	if err := br.req.Context().Err(); err != nil && keep {
+		return nil, true, fmt.Errorf("Bulk request aborted: %w", err)
+	}
+	if b.opts.Output != nil {
+		n, err := b.writeOutput(br)
+		if n == 0 && err != nil && keep {
+			return nil, true, err
+		}
+		br.release()
+		if err == nil {
+			err = br.err
+		}
+		return nil, false, err
+	}
+
+	rv, err = b.send(br, keep)
+	if rv == nil && err != nil {
+		// The server may still be reading the body.
+		return nil, keep && notApplied(err), err
+	}
+	br.release()
+	if err == BulkItemsFailed {
+		err = b.itemsFailed(rv)
+	}
+	if err == nil && br.err != nil {
+		err = br.err
+	}
+	return rv, false, err
+}
 
-	resp, err := b.es.client.Do(req)
+// Write a batch to BulkOptions.Output in place of sending it,
+// returning how much of it was written.
+func (b *bulkWriter) writeOutput(br bulkRequest) (int, error) {
+	b.outputMu.Lock()
+	n, err := b.opts.Output.Write(br.body)
+	b.outputMu.Unlock()
 	if err != nil {
-		return err
+		return n, fmt.Errorf("Couldn't write a bulk batch: %w", err)
+	}
+
+	b.statsMu.Lock()
+	b.stats.FlushedBatches++
+	b.stats.FlushedDocs += int64(len(br.offsets))
+	b.stats.BytesSent += int64(len(br.body))
+	b.statsMu.Unlock()
+
+	return n, nil
+}
+
+// The error for a response with failed items.
+func (b *bulkWriter) itemsFailed(rv *BulkResponse) error {
+	if b.opts.FailOnItemError {
+		return &BulkError{Items: rv.Failed()}
+	}
+	return BulkItemsFailed
+}
+
+// Whether a failed send provably left a batch unapplied: the server
+// couldn't be reached, or turned the whole request away.  After
+// anything else, such as a timeout or a reset connection, the
+// server may have applied it.
+func notApplied(err error) bool {
+	if isDialError(err) || errors.Is(err, ClientClosed) {
+		return true
+	}
+	var esErr *ESError
+	if errors.As(err, &esErr) {
+		switch esErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		}
+	}
+	return false
+}
+
+// Send a batch and record it in the writer's statistics.
+//
+// With keep set, a batch that wasn't applied isn't counted, since it
+// will be sent again.
+func (b *bulkWriter) send(br bulkRequest, keep bool) (*BulkResponse, error) {
+	if b.opts.BeforeFlush != nil {
+		b.opts.BeforeFlush(br.req, len(br.offsets))
+	}
+	start := time.Now()
+	rv, err := b.sendWithRetries(br)
+	elapsed := time.Since(start)
+	if err == BulkItemsFailed {
+		err = br.markSkipped(rv)
+	}
+	if rv != nil {
+		for i, ref := range br.refs {
+			if i < len(rv.Items) {
+				rv.Items[i].Ref = ref
+			}
+		}
+	}
+	if rv != nil && b.opts.OnItemResult != nil {
+		for i, ins := range br.instructions {
+			if i < len(rv.Items) {
+				b.opts.OnItemResult(ins, rv.Items[i])
+			}
+		}
+	}
+
+	docs := int64(len(br.offsets))
+	if rv == nil && keep && notApplied(err) {
+		b.statsMu.Lock()
+		b.stats.TotalFlushDuration += elapsed
+		b.statsMu.Unlock()
+		return nil, err
+	}
+	failed := docs
+	if rv != nil {
+		failed = int64(len(rv.Failed()))
+	} else if err == nil {
+		failed = 0
+	}
+
+	b.statsMu.Lock()
+	b.stats.FlushedBatches++
+	b.stats.FlushedDocs += docs
+	b.stats.FailedDocs += failed
+	b.stats.TotalFlushDuration += elapsed
+	b.statsMu.Unlock()
+
+	return rv, err
+}
+
+// Send a batch, retrying items the server was too busy to accept.
+func (b *bulkWriter) sendWithRetries(br bulkRequest) (*BulkResponse, error) {
+	rv, err := b.doRetrying(br.req, br.body)
+
+	for attempt := 0; err == BulkItemsFailed && attempt < b.opts.MaxRetries; attempt++ {
+		var retry []int
+		var body []byte
+		for i, item := range rv.Items {
+			if item.Failed() && i < len(br.offsets) &&
+				b.opts.retryable(item.Status, item.errorType()) {
+				retry = append(retry, i)
+				body = append(body, br.segment(i)...)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		ctx := br.req.Context()
+		select {
+		case <-time.After(b.opts.backoff(attempt)):
+		case <-ctx.Done():
+			return rv, fmt.Errorf("Bulk request aborted: %w", ctx.Err())
+		}
+
+		req, rerr := b.newHTTPRequest(ctx, br.req.URL.String(), body)
+		if rerr != nil {
+			return rv, rerr
+		}
+		if b.opts.BeforeFlush != nil {
+			b.opts.BeforeFlush(req, len(retry))
+		}
+		again, rerr := b.doRetrying(req, body)
+		if again == nil {
+			return rv, rerr
+		}
+		if len(again.Items) != len(retry) {
+			return rv, fmt.Errorf("Bulk retry returned %d items for %d instructions",
+				len(again.Items), len(retry))
+		}
+
+		rv.Took += again.Took
+		rv.Retries += len(retry)
+		for j, i := range retry {
+			rv.Items[i] = again.Items[j]
+		}
+		rv.Errors = len(rv.Failed()) > 0
+		err = nil
+		if rv.Errors {
+			err = BulkItemsFailed
+		}
+	}
+
+	return rv, err
+}
+
+// Send a request, re-sending it from body if the connection fails
+// or the server rejects it with a retryable status.
+func (b *bulkWriter) doRetrying(req *http.Request, body []byte) (*BulkResponse, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		rv, err := b.do(req)
+
+		// Only a failed dial proves the request never arrived; after
+		// a reset or timeout the server may already have applied it.
+		var esErr *ESError
+		retry := isDialError(err) ||
+			(errors.As(err, &esErr) && b.opts.retryable(esErr.StatusCode, esErr.Type))
+		if err == nil || !retry || ctx.Err() != nil ||
+			attempt >= b.opts.MaxConnectionRetries {
+			return rv, err
+		}
+		b.es.logf("Retrying bulk request after error: %v", err)
+
+		select {
+		case <-time.After(b.opts.backoff(attempt)):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Bulk request aborted: %w", ctx.Err())
+		}
+
+		prev := req
+		req, err = b.newHTTPRequest(ctx, req.URL.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		// Keep anything BeforeFlush added.
+		req.Header = prev.Header.Clone()
+	}
+}
+
+// Flag stale replays in a response as skipped rather than failed,
+// returning nil if no real failures remain.
+func (br bulkRequest) markSkipped(rv *BulkResponse) error {
+	for _, i := range br.replays {
+		if i < len(rv.Items) && rv.Items[i].Conflict() {
+			rv.Items[i].Skipped = true
+		}
+	}
+	rv.Errors = len(rv.Failed()) > 0
+	if rv.Errors {
+		return BulkItemsFailed
+	}
+	return nil
+}
+
+// Spare buffers for pending batches.
+var bulkBufferPool = sync.Pool{
+	New: func() interface{} { return &bytes.Buffer{} },
+}
+
+// Buffers bigger than this aren't kept for reuse.
+const maxPooledBulkBuffer = 4 * DefaultBulkMaxBytes
+
+// Recycle the batch's buffer.  Nothing may use body afterwards.
+func (br bulkRequest) release() {
+	if br.buf == nil || br.buf.Cap() > maxPooledBulkBuffer {
+		return
+	}
+	br.buf.Reset()
+	bulkBufferPool.Put(br.buf)
+}
+
+// Encoded form of the i'th instruction in the batch.
+func (br bulkRequest) segment(i int) []byte {
+	end := len(br.body)
+	if i+1 < len(br.offsets) {
+		end = br.offsets[i+1]
+	}
+	return br.body[br.offsets[i]:end]
+}
+
+func (b *bulkWriter) do(req *http.Request) (*BulkResponse, error) {
+	if req.ContentLength > 0 {
+		b.statsMu.Lock()
+		b.stats.BytesSent += req.ContentLength
+		b.statsMu.Unlock()
+	}
+
+	resp, err := b.es.do(req)
+	if err != nil {
+		if cerr := req.Context().Err(); cerr != nil {
+			return nil, fmt.Errorf("Bulk request aborted: %w", cerr)
+		}
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	// TODO: Parse the response and check each thingy.
-	if resp.StatusCode > 201 {
-		return errors.New("HTTP error:  " + resp.Status)
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		return nil, b.es.newESError(resp)
+	}
+
+	br := &BulkResponse{}
+	err = json.NewDecoder(resp.Body).Decode(br)
+	if err != nil {
+		return nil, err
+	}
+
+	if br.Errors {
+		return br, BulkItemsFailed
+	}
+
+	return br, nil
+}
+
+func (b *bulkWriter) Preview() ([]byte, error) {
+	peek := make(chan []byte)
+	select {
+	case b.peekch <- peek:
+	case <-b.done:
+		return nil, BulkClosed
+	}
+	return <-peek, nil
+}
+
+func (b *bulkWriter) Reset() error {
+	reset := make(chan struct{})
+	select {
+	case b.resetch <- reset:
+	case <-b.done:
+		return BulkClosed
+	}
+	<-reset
+	return nil
+}
+
+// Size of the pending batch, as reported by the bulk goroutine.
+type pendingSize struct {
+	bytes int
+	count int
+}
+
+func (b *bulkWriter) pending() pendingSize {
+	size := make(chan pendingSize)
+	select {
+	case b.sizech <- size:
+	case <-b.done:
+		return pendingSize{}
+	}
+	return <-size
+}
+
+func (b *bulkWriter) PendingBytes() int {
+	return b.pending().bytes
+}
+
+func (b *bulkWriter) PendingCount() int {
+	return b.pending().count
+}
+
+// A request from SetDefaultIndex for the bulk goroutine.
+type indexChange struct {
+	index string
+	done  chan struct{}
+}
+
+func (b *bulkWriter) SetDefaultIndex(index string) error {
+	b.closeMu.Lock()
+	defer b.closeMu.Unlock()
+	if b.closed {
+		return BulkClosed
 	}
 
+	change := indexChange{index, make(chan struct{})}
+	select {
+	case b.indexch <- change:
+	case <-b.done:
+		return BulkClosed
+	}
+	<-change.done
 	return nil
 }
 
-func (b *bulkWriter) Quit() {
-	b.quit <- true
+func (b *bulkWriter) SetDefaultRouting(index, routing string) {
+	b.routingMu.Lock()
+	defer b.routingMu.Unlock()
+	if routing == "" {
+		delete(b.routing, index)
+		return
+	}
+	if b.routing == nil {
+		b.routing = map[string]string{}
+	}
+	b.routing[index] = routing
+}
+
+func (b *bulkWriter) Stats() BulkStats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	stats := b.stats
+	stats.QueueDepth = len(b.update)
+	return stats
+}
+
+func (b *bulkWriter) Quit() error {
+	b.quitOnce.Do(func() {
+		b.closeMu.Lock()
+		b.closed = true
+		b.closeMu.Unlock()
+
+		errch := make(chan error)
+		b.quit <- errch
+		b.quitErr = <-errch
+
+		b.es.writersMu.Lock()
+		delete(b.es.writers, b)
+		b.es.writersMu.Unlock()
+	})
+	return b.quitErr
+}
+
+// Build a request for the pending batch and start a new one.
+//
+// The request owns the old buffer from here on; the bulk goroutine
+// only ever writes to the fresh one, so an in-flight POST can't see
+// later updates.
+func newBulkRequest(ctx context.Context, bulkUrl string, bw *bulkWriter) bulkRequest {
+	rv := bulkRequest{err: bw.err}
+	bw.err = nil
+	if len(bw.offsets) == 0 {
+		// The server rejects an empty body.
+		bw.w.Reset()
+		return rv
+	}
+
+	rv.buf = bw.w
+	rv.body = bw.w.Bytes()
+	rv.offsets = bw.offsets
+	rv.replays = bw.replays
+	rv.instructions = bw.instructions
+	rv.refs = bw.refs
+	bw.w = bulkBufferPool.Get().(*bytes.Buffer)
+	bw.offsets = nil
+	bw.replays = nil
+	bw.instructions = nil
+	bw.refs = nil
+
+	req, err := bw.newHTTPRequest(ctx, bulkUrl, rv.body)
+	if err != nil {
+		rv.err = err
+		return rv
+	}
+
+	rv.req = req
+	return rv
+}
+
+// Headers the bulk writer controls, which BulkOptions.Headers can't
+// override.
+var reservedBulkHeaders = map[string]bool{
+	"Content-Type":     true,
+	"Content-Length":   true,
+	"Content-Encoding": true,
+}
+
+func (b *bulkWriter) newHTTPRequest(ctx context.Context, bulkUrl string,
+	body []byte) (*http.Request, error) {
+
+	if b.opts.Chunked {
+		return b.newChunkedRequest(ctx, bulkUrl, body)
+	}
+
+	if b.opts.Compress {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		_, err := zw.Write(body)
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't compress a request: %v", err)
+		}
+		body = zbuf.Bytes()
+	}
+
+	req, err := b.es.newRequest(ctx, "POST", bulkUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't make a request: %v", err)
+	}
+
+	b.setHeaders(req)
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	return req, nil
+}
+
+// Like newHTTPRequest, but with no Content-Length, so the body is
+// sent with chunked encoding.  A compressed body is gzipped as it
+// is sent rather than all at once up front.
+func (b *bulkWriter) newChunkedRequest(ctx context.Context, bulkUrl string,
+	body []byte) (*http.Request, error) {
+
+	open := func() io.ReadCloser {
+		var r io.ReadCloser = io.NopCloser(bytes.NewReader(body))
+		if b.opts.Compress {
+			r = gzipStream(body)
+		}
+		return &countingReader{r, b}
+	}
+
+	req, err := b.es.newRequest(ctx, "POST", bulkUrl, open())
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't make a request: %v", err)
+	}
+	req.ContentLength = -1
+	req.GetBody = func() (io.ReadCloser, error) {
+		return open(), nil
+	}
+
+	b.setHeaders(req)
+	return req, nil
+}
+
+// Add the configured and encoding headers to a bulk request.
+func (b *bulkWriter) setHeaders(req *http.Request) {
+	for key, values := range b.opts.Headers {
+		if reservedBulkHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if b.opts.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+}
+
+// Gzip data on the fly.  Closing the reader stops the compression.
+func gzipStream(data []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := zw.Write(data)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// Request body that adds what is read to the writer's BytesSent,
+// for chunked requests whose length isn't known up front.
+type countingReader struct {
+	io.ReadCloser
+	b *bulkWriter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.b.statsMu.Lock()
+	r.b.stats.BytesSent += int64(n)
+	r.b.statsMu.Unlock()
+	return n, err
+}
+
+func issueBulkRequest(bulkUrl string, bw *bulkWriter, call batchCall) {
+	call.reply <- newBulkRequest(call.ctx, bulkUrl, bw)
+}
+
+// How long until RateLimit allows another automatic flush.
+func (b *bulkWriter) throttle() time.Duration {
+	if b.opts.RateLimit <= 0 {
+		return 0
+	}
+	interval := time.Duration(float64(time.Second) / b.opts.RateLimit)
+	return time.Until(b.lastFlush.Add(interval))
 }
 
-func issueBulkRequest(bulkUrl string, bw *bulkWriter, reqch chan *http.Request) {
-	req, err := http.NewRequest("POST", bulkUrl, bw.w)
+// Send the current batch from the bulk goroutine.
+//
+// Any error is held and reported by the next SendBatch.  If the
+// writer has a dispatch channel, the batch is handed off there
+// instead.
+func (b *bulkWriter) flush(bulkUrl string) {
+	b.lastFlush = time.Now()
+	br := newBulkRequest(context.Background(), bulkUrl, b)
+	if b.dispatch != nil {
+		b.dispatch <- br
+		return
+	}
+
+	_, _, err := b.complete(br, false)
+	if err != nil {
+		b.es.logf("Error flushing a bulk batch: %v", err)
+	}
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Serialize an instruction into the pending batch.
+//
+// The instruction is encoded separately first so a failure partway
+// through can't leave a truncated record in the batch, and so one
+// that could never fit in a batch can be rejected alone.
+func (b *bulkWriter) write(upd Instruction) {
+	buf := &bytes.Buffer{}
+	var e encoder = json.NewEncoder(buf)
+	if b.opts.Marshaler != nil {
+		e = &marshalEncoder{buf, b.opts.Marshaler}
+	}
+	err := upd.writeTo(e)
+	if err == nil && b.opts.Marshaler != nil {
+		// json.Encoder always writes one compact line per value,
+		// but a custom Marshaler might not.
+		err = checkNDJSON(buf.Bytes())
+	}
 	if err != nil {
-		log.Fatalf("Couldn't make a request: %v\n", err)
+		err = fmt.Errorf("Error encoding an update: %v", err)
+	} else if limit := b.opts.maxBytes(); limit > 0 && buf.Len() > limit {
+		action, _, _ := bytes.Cut(buf.Bytes(), []byte("\n"))
+		err = fmt.Errorf("%w: %d bytes is over the %d byte limit: %s",
+			BulkDocumentTooLarge, buf.Len(), limit, action)
+	}
+	if err != nil {
+		b.es.logf("Dropping bulk instruction: %v", err)
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	if _, ok := upd.(*ReplayInstruction); ok {
+		b.replays = append(b.replays, len(b.offsets))
+	}
+	if b.opts.OnItemResult != nil {
+		b.instructions = append(b.instructions, upd)
+	}
+	var ref interface{}
+	if ri, ok := upd.(referenced); ok {
+		ref = ri.ref()
+	}
+	b.refs = append(b.refs, ref)
+	b.offsets = append(b.offsets, b.w.Len())
+	b.w.Write(buf.Bytes())
+}
+
+// Check that data is one or more newline-terminated lines of JSON,
+// as the bulk endpoint requires.  A missing terminator would merge
+// this record with the next one and fail the whole batch.
+func checkNDJSON(data []byte) error {
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		return errors.New("Bulk record isn't newline-terminated")
+	}
+	for _, line := range bytes.Split(data[:len(data)-1], []byte("\n")) {
+		if !json.Valid(line) {
+			return fmt.Errorf("Bulk record line isn't a single JSON value: %q",
+				line)
+		}
+	}
+	return nil
+}
+
+// Default size at which a bulk batch is sent automatically.  This is
+// the commonly recommended bulk request size, well under the
+// server's 100mb default http.max_content_length.
+const DefaultBulkMaxBytes = 5 * 1024 * 1024
+
+// Options controlling a bulk updater.
+type BulkOptions struct {
+	// Send the batch automatically once it grows to at least
+	// this many bytes.  Zero means DefaultBulkMaxBytes, and a
+	// negative value disables size-based flushing.
+	MaxBytes int
+	// Also send the batch once it holds this many instructions.
+	// Zero means no limit.
+	MaxActions int
+	// Send any pending batch automatically this often.  Zero
+	// disables time-based flushing.
+	FlushInterval time.Duration
+	// Most batches per second to send automatically.  While a
+	// batch is held back the writer keeps batching up to MaxBytes,
+	// then Update blocks.  Zero means no limit.
+	RateLimit float64
+	// Re-send items that failed as RetryableFunc allows up to this
+	// many times.  Other item failures are never retried.
+	MaxRetries int
+	// Re-send a whole request up to this many times if the
+	// server can't be connected to, or rejects it as RetryableFunc
+	// allows.  Connections that fail later, e.g. are reset by a
+	// load balancer, aren't retried: the server may have applied
+	// the batch, and sending script updates or instructions
+	// without an Id again would apply them twice.
+	MaxConnectionRetries int
+	// Whether a failure with this status, and server error type if
+	// any, is worth retrying.  Nil means DefaultBulkRetryable.
+	RetryableFunc func(statusCode int, errorType string) bool
+	// How long to wait before each retry.  Nil means
+	// DefaultBulkBackoff.
+	BackoffFunc func(attempt int) time.Duration
+	// Gzip request bodies.  The server must have http.compression
+	// enabled.
+	Compress bool
+	// Send request bodies with chunked encoding rather than a
+	// Content-Length.  With Compress, this saves holding a
+	// compressed copy of each batch in memory; the batch itself is
+	// still assembled in memory, up to MaxBytes.
+	Chunked bool
+
+	// Refresh policy for each batch: "", "true", "false" or
+	// "wait_for".  "wait_for" makes each batch wait until its
+	// documents are visible to search.
+	Refresh string
+	// Default ingest pipeline for instructions that don't set their
+	// own.
+	Pipeline string
+	// Default routing for instructions that don't set their own.
+	Routing string
+	// Send batches to this index's bulk endpoint, and leave _index
+	// out of actions for it.  Instructions must still name their
+	// index.  Those passed to OnItemResult have it cleared.
+	Index string
+	// Mapping type for instructions that don't set their own, for
+	// clusters older than 7.0 that require one.  Otherwise an empty
+	// Type is left out of the action, as 7.0 and later expect.
+	DefaultType string
+	// Don't contact the server.  SendBatch returns the body it
+	// would have sent in BulkResponse.Body, and automatic flushes
+	// discard their batches.
+	DryRun bool
+	// Write each batch here, as the NDJSON that would have been
+	// sent, rather than contacting the server.  SendBatch then
+	// returns a nil response.  ReplayFile can send the result
+	// later.
+	Output io.Writer
+	// Called with each bulk request just before it is sent, along
+	// with the number of instructions in it, including retries.
+	// The request's headers may be changed.
+	BeforeFlush func(req *http.Request, itemCount int)
+	// Called with each instruction and its final result after every
+	// batch the server answered, in batch order.  This is called
+	// from whichever goroutine sent the batch.
+	OnItemResult func(instruction Instruction, result BulkItemResult)
+	// Encodes each line of the bulk body, documents and action
+	// metadata alike.  Nil means json.Marshal.  The output must be
+	// a single line of JSON.
+	Marshaler func(v interface{}) ([]byte, error)
+	// Return a BulkError describing the failed items rather than
+	// plain BulkItemsFailed.
+	FailOnItemError bool
+	// Extra headers for every bulk request, such as X-Opaque-Id.
+	// Content-Type, Content-Length and Content-Encoding are
+	// ignored.
+	Headers http.Header
+
+	// Number of instructions Update can queue ahead of the bulk
+	// goroutine, so fast producers needn't wait for each one to be
+	// encoded.  Zero means Update hands each one over directly, and
+	// blocks while a batch is being flushed.
+	BufferSize int
+	// Return BulkBufferFull from Update rather than blocking when
+	// the queue is full.
+	FailWhenFull bool
+}
+
+func (o *BulkOptions) params() map[string]string {
+	params := map[string]string{}
+	if o.Refresh != "" {
+		params["refresh"] = o.Refresh
+	}
+	if o.Pipeline != "" {
+		params["pipeline"] = o.Pipeline
+	}
+	if o.Routing != "" {
+		params["routing"] = o.Routing
+	}
+	return params
+}
+
+// Longest wait DefaultBulkBackoff gives.
+const DefaultBulkMaxBackoff = 30 * time.Second
+
+// Exponential backoff starting at 100ms and capped at
+// DefaultBulkMaxBackoff.  Up to a fifth of each wait is taken off at
+// random so writers that failed together don't retry in step.
+func DefaultBulkBackoff(attempt int) time.Duration {
+	d := DefaultBulkMaxBackoff
+	if attempt >= 0 && attempt < 20 {
+		if exp := (100 * time.Millisecond) << uint(attempt); exp < d {
+			d = exp
+		}
+	}
+	return d - time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+func (o *BulkOptions) maxBytes() int {
+	if o.MaxBytes == 0 {
+		return DefaultBulkMaxBytes
 	}
+	return o.MaxBytes
+}
+
+// Retry when the server or a gateway in front of it is busy or
+// unavailable: 429, 502, 503 and 504.
+func DefaultBulkRetryable(statusCode int, errorType string) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
 
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", bw.w.Len()))
-	req.Header.Set("Content-Type", "application/json")
+func (o *BulkOptions) retryable(statusCode int, errorType string) bool {
+	if o.RetryableFunc == nil {
+		return DefaultBulkRetryable(statusCode, errorType)
+	}
+	return o.RetryableFunc(statusCode, errorType)
+}
 
-	reqch <- req
-	bw.w = &bytes.Buffer{}
+func (o *BulkOptions) backoff(attempt int) time.Duration {
+	if o.BackoffFunc == nil {
+		return DefaultBulkBackoff(attempt)
+	}
+	return o.BackoffFunc(attempt)
 }
 
 // Get a bulk updater.
-func (es *ElasticSearch) Bulk() BulkUpdater {
+//
+// opts may be nil to use the defaults.  If the updater is still
+// running when the client is closed, Close shuts it down.
+func (es *ElasticSearch) Bulk(opts *BulkOptions) BulkUpdater {
+	rv := es.newBulkWriter(opts)
+	es.writersMu.Lock()
+	if es.writers == nil {
+		es.writers = map[*bulkWriter]bool{}
+	}
+	es.writers[rv] = true
+	es.writersMu.Unlock()
+
+	rv.start()
+	return rv
+}
+
+func (es *ElasticSearch) newBulkWriter(opts *BulkOptions) *bulkWriter {
 	rv := &bulkWriter{
-		es:     es,
-		update: make(chan Instruction),
-		reqch:  make(chan chan *http.Request),
-		quit:   make(chan bool),
-		w:      &bytes.Buffer{},
+		es:      es,
+		reqch:   make(chan batchCall),
+		peekch:  make(chan chan []byte),
+		sizech:  make(chan chan pendingSize),
+		resetch: make(chan chan struct{}),
+		indexch: make(chan indexChange),
+		restore: make(chan bulkRequest),
+		quit:    make(chan chan error),
+		done:    make(chan struct{}),
+		w:       &bytes.Buffer{},
+	}
+	if opts != nil {
+		rv.opts = *opts
+	}
+	rv.update = make(chan Instruction, rv.opts.BufferSize)
+	return rv
+}
+
+// URL for this writer's bulk requests.
+func (b *bulkWriter) url() string {
+	u := b.es.url("_bulk")
+	if b.opts.Index != "" {
+		u = b.es.url(b.opts.Index, "_bulk")
 	}
+	updateUrlQuery(u, b.opts.params())
+	return u.String()
+}
+
+// Start the goroutine that owns the writer's buffer.
+func (b *bulkWriter) start() {
+	bulkUrl := b.url()
+	maxBytes := b.opts.maxBytes()
 
-	bulkUrl := es.url("_bulk").String()
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	if b.opts.FlushInterval > 0 {
+		ticker = time.NewTicker(b.opts.FlushInterval)
+		tick = ticker.C
+	}
 
 	go func() {
+		defer close(b.done)
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+
+		// Fires when a flush held back by RateLimit may go ahead.
+		var wake <-chan time.Time
+		// Set when FlushInterval has asked for a flush.
+		var due bool
+
+		// Send the batch if it's full or due, and the rate limit
+		// allows.
+		maybeFlush := func() {
+			due = due && b.w.Len() > 0
+			full := (maxBytes > 0 && b.w.Len() >= maxBytes) ||
+				(b.opts.MaxActions > 0 && len(b.offsets) >= b.opts.MaxActions)
+			if !full && !due {
+				return
+			}
+			if wait := b.throttle(); wait > 0 {
+				if wake == nil {
+					wake = time.After(wait)
+				}
+				return
+			}
+			due = false
+			b.flush(bulkUrl)
+		}
+		// Add an instruction to the batch.
+		add := func(upd Instruction) {
+			b.write(upd)
+			maybeFlush()
+		}
+		// Move everything already queued into the batch.
+		drain := func() {
+			for n := len(b.update); n > 0; n-- {
+				add(<-b.update)
+			}
+		}
+
 		for {
+			// While a flush is held back, keep batching up to
+			// MaxBytes and then stop taking updates.
+			updates := b.update
+			if wake != nil && maxBytes > 0 && b.w.Len() >= maxBytes {
+				updates = nil
+			}
+
 			select {
-			case <-rv.quit:
-				break
+			case errch := <-b.quit:
+				drain()
+				if b.w.Len() > 0 {
+					b.flush(bulkUrl)
+				}
+				errch <- b.err
+				return
 
-			case req := <-rv.reqch:
-				issueBulkRequest(bulkUrl, rv, req)
+			case <-tick:
+				due = true
+				maybeFlush()
 
-			case upd := <-rv.update:
-				err := upd.writeTo(rv.w)
-				if err != nil {
-					log.Fatalf("Error sending an update: %v", err)
+			case req := <-b.reqch:
+				drain()
+				issueBulkRequest(bulkUrl, b, req)
+
+			case br := <-b.restore:
+				if br.req.URL.String() != bulkUrl {
+					// Its actions may rely on the old bulk URL.
+					b.es.logf("Dropping a failed bulk batch for %s", br.req.URL)
+					continue
+				}
+				b.unshift(br)
+
+			case change := <-b.indexch:
+				drain()
+				if b.w.Len() > 0 {
+					b.flush(bulkUrl)
 				}
+				b.routingMu.Lock()
+				b.opts.Index = change.index
+				b.routingMu.Unlock()
+				bulkUrl = b.url()
+				close(change.done)
+
+			case peek := <-b.peekch:
+				drain()
+				peek <- append([]byte(nil), b.w.Bytes()...)
+
+			case size := <-b.sizech:
+				drain()
+				size <- pendingSize{b.w.Len(), len(b.offsets)}
+
+			case reset := <-b.resetch:
+				for n := len(b.update); n > 0; n-- {
+					<-b.update
+				}
+				b.w.Reset()
+				b.offsets = nil
+				b.replays = nil
+				b.instructions = nil
+				b.refs = nil
+				close(reset)
+
+			case <-wake:
+				wake = nil
+				maybeFlush()
+
+			case upd := <-updates:
+				add(upd)
 			}
 		}
 	}()
-
-	return rv
 }
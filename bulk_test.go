@@ -0,0 +1,699 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake _bulk endpoint that accepts every action, or fails whole
+// requests with status while it's non-zero.
+type testBulkServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status int
+	// Statuses to fail the next whole requests with, before status
+	// applies.
+	queue []int
+	// Statuses to answer the next sends of each document id with,
+	// before it's accepted.
+	fail    map[string][]int
+	actions int
+	bodies  [][]byte
+	headers []http.Header
+	// Don't keep bodies and headers, for benchmarks.
+	discard bool
+}
+
+func newTestBulkServer(t testing.TB) *testBulkServer {
+	s := &testBulkServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testBulkServer) setStatus(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+func (s *testBulkServer) serve(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.discard {
+		s.bodies = append(s.bodies, data)
+		s.headers = append(s.headers, r.Header.Clone())
+	}
+	status := s.status
+	if len(s.queue) > 0 {
+		status, s.queue = s.queue[0], s.queue[1:]
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"type":"test_error","reason":"failing"},"status":%d}`,
+			status)
+		return
+	}
+
+	var items []map[string]map[string]interface{}
+	failed := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var action map[string]struct {
+			Id string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for name, meta := range action {
+			result := map[string]interface{}{"_id": meta.Id, "status": http.StatusOK}
+			if statuses := s.fail[meta.Id]; len(statuses) > 0 {
+				result["status"] = statuses[0]
+				result["error"] = map[string]string{"type": "test_error"}
+				s.fail[meta.Id] = statuses[1:]
+				failed = true
+			}
+			items = append(items, map[string]map[string]interface{}{name: result})
+			if name != "delete" {
+				scanner.Scan()
+			}
+		}
+	}
+	s.actions += len(items)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"took": 1, "errors": failed, "items": items,
+	})
+}
+
+func newTestClient(t testing.TB, baseURL string) *ElasticSearch {
+	es, err := NewWithClient(baseURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+func TestBulkNDJSON(t *testing.T) {
+	es := newTestClient(t, "http://localhost:9200")
+	b := es.Bulk(&BulkOptions{DryRun: true})
+	defer b.Quit()
+
+	instructions := []Instruction{
+		&UpdateInstruction{Id: "1", Index: "a",
+			Body: map[string]interface{}{"n": 1}},
+		&DeleteInstruction{Id: "2", Index: "a"},
+		&PartialUpdateInstruction{Id: "3", Index: "a",
+			Doc: map[string]interface{}{"n": 3}, DocAsUpsert: true},
+		&DeleteInstruction{Id: "4", Index: "b", Routing: "r"},
+	}
+	for _, ins := range instructions {
+		if err := b.Update(ins); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `{"index":{"_id":"1","_index":"a"}}
+{"n":1}
+{"delete":{"_id":"2","_index":"a"}}
+{"update":{"_id":"3","_index":"a"}}
+{"doc":{"n":3},"doc_as_upsert":true}
+{"delete":{"_id":"4","_index":"b","_routing":"r"}}
+`
+	got, err := b.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Preview() =\n%s\nwant\n%s", got, want)
+	}
+
+	rv, err := b.SendBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rv.Body) != want {
+		t.Errorf("SendBatch() body =\n%s\nwant\n%s", rv.Body, want)
+	}
+}
+
+func TestBulkQuitStopsGoroutine(t *testing.T) {
+	es := newTestClient(t, "http://localhost:9200")
+	b := es.Bulk(nil).(*bulkWriter)
+
+	if err := b.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-b.done:
+	case <-time.After(time.Second):
+		t.Fatal("Bulk goroutine still running after Quit")
+	}
+
+	err := b.Update(&DeleteInstruction{Id: "1", Index: "a"})
+	if err != BulkClosed {
+		t.Errorf("Update after Quit = %v, want BulkClosed", err)
+	}
+}
+
+func TestBulkConcurrentUpdateAndSendBatch(t *testing.T) {
+	srv := newTestBulkServer(t)
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{MaxActions: 7})
+
+	const writers, docs = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < docs; i++ {
+				err := b.Update(&IndexInstruction{
+					Id:    fmt.Sprintf("%d-%d", w, i),
+					Index: "a",
+					Body:  map[string]interface{}{"n": i},
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if i%10 == 0 {
+					if _, err := b.SendBatch(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := b.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.actions != writers*docs {
+		t.Errorf("Server got %d actions, want %d", srv.actions, writers*docs)
+	}
+}
+
+func TestBulkCompress(t *testing.T) {
+	srv := newTestBulkServer(t)
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{Compress: true})
+	defer b.Quit()
+
+	for i := 0; i < 3; i++ {
+		err := b.Update(&IndexInstruction{Id: fmt.Sprint(i), Index: "a",
+			Body: map[string]interface{}{"n": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := b.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SendBatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 1 {
+		t.Fatalf("Server got %d requests, want 1", len(srv.bodies))
+	}
+	if enc := srv.headers[0].Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", enc)
+	}
+	if !bytes.Equal(srv.bodies[0], want) {
+		t.Errorf("Decompressed body =\n%s\nwant\n%s", srv.bodies[0], want)
+	}
+}
+
+func TestBulkKeepsBatchAfterFailedSend(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.setStatus(http.StatusServiceUnavailable)
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(nil)
+	defer b.Quit()
+
+	for i := 0; i < 2; i++ {
+		err := b.Update(&IndexInstruction{Id: fmt.Sprint(i), Index: "a",
+			Body: map[string]interface{}{"n": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.SendBatch(); err == nil {
+		t.Fatal("SendBatch succeeded against a failing server")
+	}
+	if n := b.PendingCount(); n != 2 {
+		t.Fatalf("PendingCount() after a failed send = %d, want 2", n)
+	}
+
+	srv.setStatus(0)
+	if _, err := b.SendBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.PendingCount(); n != 0 {
+		t.Errorf("PendingCount() after a good send = %d, want 0", n)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.actions != 2 {
+		t.Errorf("Server got %d actions, want 2", srv.actions)
+	}
+}
+
+func TestBulkOutputWrittenOnce(t *testing.T) {
+	es := newTestClient(t, "http://localhost:9200")
+	out := &bytes.Buffer{}
+	b := es.Bulk(&BulkOptions{Output: out})
+	defer b.Quit()
+
+	err := b.Update(&IndexInstruction{Id: "1", Index: "a",
+		Body: map[string]interface{}{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Held as an encoding error for the batch.
+	err = b.Update(&IndexInstruction{Id: "2", Index: "a",
+		Body: map[string]interface{}{"n": make(chan int)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SendBatch(); err == nil {
+		t.Fatal("SendBatch didn't report the encoding error")
+	}
+	if _, err := b.SendBatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{\"index\":{\"_id\":\"1\",\"_index\":\"a\"}}\n{\"n\":1}\n"
+	if out.String() != want {
+		t.Errorf("Output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func BenchmarkBulkSendBatch(b *testing.B) {
+	srv := newTestBulkServer(b)
+	srv.discard = true
+	es := newTestClient(b, srv.URL)
+	w := es.Bulk(nil)
+	defer w.Quit()
+
+	body := map[string]interface{}{"message": "hello", "n": 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			err := w.Update(&IndexInstruction{Index: "a", Body: body})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := w.SendBatch(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBulkNoRetryAfterDroppedConnection(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		// Applied, but the answer never gets back.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{MaxConnectionRetries: 2,
+		BackoffFunc: func(int) time.Duration { return 0 }})
+	defer b.Quit()
+
+	err := b.Update(&ScriptUpdateInstruction{Id: "1", Index: "a",
+		Script: Script{Source: "ctx._source.n++"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SendBatch(); err == nil {
+		t.Fatal("SendBatch succeeded over a dropped connection")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 1 {
+		t.Errorf("Server got the batch %d times, want 1", hits)
+	}
+}
+
+func TestBulkDropsBatchThatMayHaveBeenApplied(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.setStatus(http.StatusInternalServerError)
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(nil)
+	defer b.Quit()
+
+	err := b.Update(&IndexInstruction{Index: "a",
+		Body: map[string]interface{}{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SendBatch(); err == nil {
+		t.Fatal("SendBatch succeeded against a failing server")
+	}
+	if n := b.PendingCount(); n != 0 {
+		t.Errorf("PendingCount() after a 500 = %d, want 0", n)
+	}
+	if failed := b.Stats().FailedDocs; failed != 1 {
+		t.Errorf("FailedDocs = %d, want 1", failed)
+	}
+}
+
+func TestBulkDropsBatchAfterTimeout(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		hits++
+		first := hits == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(nil)
+	err := b.Update(&IndexInstruction{Index: "a",
+		Body: map[string]interface{}{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.SendBatchContext(ctx); err == nil {
+		t.Fatal("SendBatchContext outlived its deadline")
+	}
+	if err := b.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 1 {
+		t.Errorf("Server got the batch %d times, want 1", hits)
+	}
+}
+
+func TestBulkRawDryRunAndOutput(t *testing.T) {
+	srv := newTestBulkServer(t)
+	es := newTestClient(t, srv.URL)
+	data := "{\"index\":{\"_index\":\"a\"}}\n{\"n\":1}\n{\"delete\":{\"_index\":\"a\",\"_id\":\"1\"}}\n"
+
+	rv, err := es.BulkRaw(context.Background(), strings.NewReader(data),
+		&BulkOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rv.Body) != data {
+		t.Errorf("DryRun body =\n%s\nwant\n%s", rv.Body, data)
+	}
+
+	out := &bytes.Buffer{}
+	_, err = es.BulkRaw(context.Background(), strings.NewReader(data),
+		&BulkOptions{Output: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != data {
+		t.Errorf("Output =\n%s\nwant\n%s", out, data)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 0 {
+		t.Errorf("Server got %d requests, want none", len(srv.bodies))
+	}
+}
+
+func TestBulkRateLimitsIntervalFlushes(t *testing.T) {
+	srv := newTestBulkServer(t)
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{RateLimit: 2, FlushInterval: 10 * time.Millisecond})
+	defer b.Quit()
+
+	deadline := time.Now().Add(600 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		err := b.Update(&IndexInstruction{Index: "a",
+			Body: map[string]interface{}{"n": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	// At 2 a second, 600ms allows the first flush and one more.
+	if n := len(srv.bodies); n < 1 || n > 2 {
+		t.Errorf("Server got %d batches, want 1 or 2", n)
+	}
+}
+
+// Send one small batch with DebugRequestBodies set, returning the
+// body Preview gave, BytesSent and the SendBatch error.
+func sendDebugBatch(t *testing.T, status int, opts *BulkOptions) ([]byte, int64, error) {
+	srv := newTestBulkServer(t)
+	srv.setStatus(status)
+	es := newTestClient(t, srv.URL)
+	es.DebugRequestBodies = true
+	b := es.Bulk(opts)
+	defer b.Quit()
+
+	err := b.Update(&IndexInstruction{Id: "1", Index: "a",
+		Body: map[string]interface{}{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := b.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.SendBatch()
+	return body, b.Stats().BytesSent, err
+}
+
+func TestBulkDebugRequestBodyCompressed(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		opts := &BulkOptions{Compress: true, Chunked: chunked}
+		want, _, err := sendDebugBatch(t, http.StatusBadRequest, opts)
+		var esErr *ESError
+		if !errors.As(err, &esErr) {
+			t.Fatalf("SendBatch() error = %v, want an ESError", err)
+		}
+		if esErr.RequestBody != string(want) {
+			t.Errorf("Chunked %v: RequestBody = %q, want %q", chunked,
+				esErr.RequestBody, want)
+		}
+	}
+}
+
+func TestBulkDebugRequestBodyNotCounted(t *testing.T) {
+	opts := &BulkOptions{Compress: true, Chunked: true}
+	_, ok, _ := sendDebugBatch(t, 0, opts)
+	_, failed, _ := sendDebugBatch(t, http.StatusBadRequest, opts)
+	if failed != ok {
+		t.Errorf("BytesSent = %d after a failure, want %d", failed, ok)
+	}
+}
+
+// Queue index instructions for the given ids.
+func updateIds(t *testing.T, b BulkUpdater, ids ...string) {
+	for _, id := range ids {
+		err := b.Update(&IndexInstruction{Id: id, Index: "a",
+			Body: map[string]interface{}{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func noBackoff(int) time.Duration {
+	return 0
+}
+
+func TestBulkRetriesRetryableItems(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.fail = map[string][]int{
+		"2": {http.StatusTooManyRequests},
+		"3": {http.StatusBadRequest},
+	}
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{MaxRetries: 2, BackoffFunc: noBackoff})
+	defer b.Quit()
+
+	updateIds(t, b, "1", "2", "3")
+	rv, err := b.SendBatch()
+	if err != BulkItemsFailed {
+		t.Fatalf("SendBatch() error = %v, want BulkItemsFailed", err)
+	}
+	if rv.Retries != 1 {
+		t.Errorf("Retries = %d, want 1", rv.Retries)
+	}
+	wantStatus := map[string]int{"1": 200, "2": 200, "3": 400}
+	if len(rv.Items) != 3 {
+		t.Fatalf("Got %d items, want 3", len(rv.Items))
+	}
+	for i, id := range []string{"1", "2", "3"} {
+		item := rv.Items[i]
+		if item.Id != id || item.Status != wantStatus[id] {
+			t.Errorf("Item %d = %s/%d, want %s/%d", i, item.Id, item.Status,
+				id, wantStatus[id])
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 2 {
+		t.Fatalf("Server got %d requests, want 2", len(srv.bodies))
+	}
+	want := "{\"index\":{\"_id\":\"2\",\"_index\":\"a\"}}\n{\"id\":\"2\"}\n"
+	if string(srv.bodies[1]) != want {
+		t.Errorf("Retry body =\n%s\nwant\n%s", srv.bodies[1], want)
+	}
+}
+
+func TestBulkItemRetriesGiveUp(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.fail = map[string][]int{"1": {429, 429, 429}}
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{MaxRetries: 2, BackoffFunc: noBackoff})
+	defer b.Quit()
+
+	updateIds(t, b, "1", "2")
+	rv, err := b.SendBatch()
+	if err != BulkItemsFailed {
+		t.Fatalf("SendBatch() error = %v, want BulkItemsFailed", err)
+	}
+	if rv.Retries != 2 {
+		t.Errorf("Retries = %d, want 2", rv.Retries)
+	}
+	if rv.Items[0].Status != 429 || rv.Items[1].Status != 200 {
+		t.Errorf("Statuses = %d, %d, want 429, 200",
+			rv.Items[0].Status, rv.Items[1].Status)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 3 {
+		t.Errorf("Server got %d requests, want 3", len(srv.bodies))
+	}
+}
+
+func TestBulkRetriesWholeRequest(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.queue = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(&BulkOptions{MaxConnectionRetries: 2, BackoffFunc: noBackoff})
+	defer b.Quit()
+
+	updateIds(t, b, "1", "2")
+	rv, err := b.SendBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rv.Items) != 2 {
+		t.Errorf("Got %d items, want 2", len(rv.Items))
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 3 {
+		t.Errorf("Server got %d requests, want 3", len(srv.bodies))
+	}
+	for i, body := range srv.bodies[1:] {
+		if !bytes.Equal(body, srv.bodies[0]) {
+			t.Errorf("Retry %d body =\n%s\nwant\n%s", i+1, body, srv.bodies[0])
+		}
+	}
+}
+
+func TestDefaultBulkBackoff(t *testing.T) {
+	if d := DefaultBulkBackoff(0); d < 80*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("DefaultBulkBackoff(0) = %v, want 80-100ms", d)
+	}
+	for attempt := 1; attempt < 100; attempt++ {
+		d := DefaultBulkBackoff(attempt)
+		if d <= 0 || d > DefaultBulkMaxBackoff {
+			t.Fatalf("DefaultBulkBackoff(%d) = %v", attempt, d)
+		}
+	}
+}
+
+func TestBulkStaleReplaySkipped(t *testing.T) {
+	srv := newTestBulkServer(t)
+	srv.fail = map[string][]int{"1": {http.StatusConflict}}
+	es := newTestClient(t, srv.URL)
+	b := es.Bulk(nil)
+	defer b.Quit()
+
+	for _, id := range []string{"1", "2"} {
+		err := b.Update(&ReplayInstruction{Id: id, Index: "a", Version: 3,
+			Body: map[string]interface{}{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rv, err := b.SendBatch()
+	if err != nil {
+		t.Fatalf("SendBatch() error = %v, want nil", err)
+	}
+	if !rv.Items[0].Skipped || rv.Items[0].Failed() {
+		t.Errorf("Stale replay = %+v, want skipped", rv.Items[0])
+	}
+	if rv.Items[1].Skipped {
+		t.Errorf("Fresh replay was skipped")
+	}
+	if rv.Errors {
+		t.Errorf("Errors set with only a stale replay failing")
+	}
+}
@@ -0,0 +1,170 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Send pre-encoded NDJSON bulk data from r.
+//
+// The data is sent in batches of at most opts.MaxBytes, split only
+// between records so each action stays with its source line.  The
+// responses are merged into one, with errors reported as for
+// SendBatch.  opts may be nil to use the defaults; the flush options
+// don't apply.  With DryRun the server isn't contacted and the
+// response's Body holds every batch, and with Output the batches are
+// written there instead.
+func (es *ElasticSearch) BulkRaw(ctx context.Context, r io.Reader,
+	opts *BulkOptions) (*BulkResponse, error) {
+
+	b := es.newBulkWriter(opts)
+	bulkUrl := b.url()
+	limit := b.opts.maxBytes()
+
+	rv := &BulkResponse{}
+	var pending error
+	var chunk []byte
+	var offsets []int
+
+	sendChunk := func() error {
+		if len(offsets) == 0 {
+			return nil
+		}
+		if b.opts.DryRun {
+			rv.Body = append(rv.Body, chunk...)
+			chunk, offsets = nil, nil
+			return nil
+		}
+		if b.opts.Output != nil {
+			_, err := b.writeOutput(bulkRequest{body: chunk, offsets: offsets})
+			chunk, offsets = nil, nil
+			return err
+		}
+		req, err := b.newHTTPRequest(ctx, bulkUrl, chunk)
+		if err != nil {
+			return err
+		}
+		resp, err := b.send(bulkRequest{req: req, body: chunk, offsets: offsets}, false)
+		chunk, offsets = nil, nil
+		if resp != nil {
+			rv.merge(resp)
+		}
+		if err == BulkItemsFailed {
+			return nil
+		}
+		return err
+	}
+
+	records := newRecordReader(r)
+	for {
+		record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rv, err
+		}
+
+		if limit > 0 && len(record) > limit {
+			if pending == nil {
+				pending = fmt.Errorf("%w: %d bytes is over the %d byte limit",
+					BulkDocumentTooLarge, len(record), limit)
+			}
+			continue
+		}
+		if limit > 0 && len(chunk)+len(record) > limit {
+			if err := sendChunk(); err != nil {
+				return rv, err
+			}
+		}
+
+		offsets = append(offsets, len(chunk))
+		chunk = append(chunk, record...)
+	}
+
+	if err := sendChunk(); err != nil {
+		return rv, err
+	}
+
+	if rv.Errors {
+		return rv, b.itemsFailed(rv)
+	}
+	return rv, pending
+}
+
+// Send a file of NDJSON bulk data, such as one written through
+// BulkOptions.Output, as for BulkRaw.
+func (es *ElasticSearch) ReplayFile(ctx context.Context, path string,
+	opts *BulkOptions) (*BulkResponse, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return es.BulkRaw(ctx, f, opts)
+}
+
+// Fold another batch's response into this one.
+func (br *BulkResponse) merge(other *BulkResponse) {
+	br.Took += other.Took
+	br.Errors = br.Errors || other.Errors
+	br.Items = append(br.Items, other.Items...)
+	br.Retries += other.Retries
+}
+
+// Reads whole bulk records (an action line plus its source line, if
+// the action takes one) from NDJSON.
+type recordReader struct {
+	r *bufio.Reader
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: bufio.NewReader(r)}
+}
+
+// Read the next non-empty, newline-terminated line.
+func (rr *recordReader) line() ([]byte, error) {
+	for {
+		line, err := rr.r.ReadBytes('\n')
+		if len(line) > 0 && err == io.EOF {
+			line = append(line, '\n')
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 1 {
+			return line, nil
+		}
+	}
+}
+
+func (rr *recordReader) next() ([]byte, error) {
+	action, err := rr.line()
+	if err != nil {
+		return nil, err
+	}
+
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(action, &meta); err != nil {
+		return nil, fmt.Errorf("Bad bulk action line %q: %v", action, err)
+	}
+	if _, ok := meta["delete"]; ok {
+		return action, nil
+	}
+
+	source, err := rr.line()
+	if err == io.EOF {
+		return nil, fmt.Errorf("Bulk action %q has no source line", action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(action, source...), nil
+}
@@ -0,0 +1,152 @@
+package elasticsearch
+
+import (
+	"strconv"
+)
+
+// A document that couldn't be processed by a by-query operation.
+type ByQueryFailure struct {
+	Index  string        `json:"index"`
+	Type   string        `json:"type"`
+	Id     string        `json:"id"`
+	Status int           `json:"status"`
+	Cause  BulkItemError `json:"cause"`
+}
+
+// Result of a by-query operation such as DeleteByQuery or Reindex.
+type ByQueryResponse struct {
+	Took             int              `json:"took"`
+	TimedOut         bool             `json:"timed_out"`
+	Total            int64            `json:"total"`
+	Created          int64            `json:"created"`
+	Updated          int64            `json:"updated"`
+	Deleted          int64            `json:"deleted"`
+	Noops            int64            `json:"noops"`
+	Batches          int64            `json:"batches"`
+	VersionConflicts int64            `json:"version_conflicts"`
+	Failures         []ByQueryFailure `json:"failures"`
+	// Set instead of the counts when the operation was started
+	// without waiting for completion.
+	Task string `json:"task"`
+}
+
+// A by-query request being assembled from options.
+type byQueryRequest struct {
+	params map[string]string
+	// Extra fields for the request body and its "source" section.
+	// Only Reindex builds its own body, so only it uses these.
+	body   map[string]interface{}
+	source map[string]interface{}
+}
+
+func newByQueryRequest() *byQueryRequest {
+	return &byQueryRequest{
+		params: map[string]string{},
+		body:   map[string]interface{}{},
+		source: map[string]interface{}{},
+	}
+}
+
+// Option for DeleteByQuery and Reindex.
+type ByQueryOption func(r *byQueryRequest)
+
+type DeleteByQueryOption = ByQueryOption
+
+// Option for Reindex.  Every ByQueryOption is one, as are the options
+// that only make sense for a reindex, such as ReindexQuery.
+type ReindexOption interface {
+	applyReindex(r *byQueryRequest)
+}
+
+func (opt ByQueryOption) applyReindex(r *byQueryRequest) {
+	opt(r)
+}
+
+// A ReindexOption that DeleteByQuery doesn't take.
+type reindexOnlyOption func(r *byQueryRequest)
+
+func (opt reindexOnlyOption) applyReindex(r *byQueryRequest) {
+	opt(r)
+}
+
+// Whether to block until the operation finishes.  When false, the
+// response only carries the Task ID of the background operation.
+func WaitForCompletion(wait bool) ByQueryOption {
+	return func(r *byQueryRequest) {
+		r.params["wait_for_completion"] = strconv.FormatBool(wait)
+	}
+}
+
+// What to do on version conflicts: "abort" (the default) or
+// "proceed".
+func Conflicts(mode string) ByQueryOption {
+	return func(r *byQueryRequest) {
+		r.params["conflicts"] = mode
+	}
+}
+
+// Only reindex documents matching this query clause.
+func ReindexQuery(query interface{}) ReindexOption {
+	return reindexOnlyOption(func(r *byQueryRequest) {
+		r.source["query"] = query
+	})
+}
+
+// Transform each document with a script while reindexing.
+func ReindexScript(script Script) ReindexOption {
+	return reindexOnlyOption(func(r *byQueryRequest) {
+		r.body["script"] = script
+	})
+}
+
+// Delete every document in an index matching a query.
+//
+// query is marshaled as the request body, e.g. {"query": {...}}.
+func (es *ElasticSearch) DeleteByQuery(index string, query interface{},
+	opts ...DeleteByQueryOption) (*ByQueryResponse, error) {
+
+	r := newByQueryRequest()
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	u := es.url(index, "_delete_by_query")
+	updateUrlQuery(u, r.params)
+
+	rv := &ByQueryResponse{}
+	_, err := es.call("POST", u.String(), query, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// Copy documents from one index into another.
+func (es *ElasticSearch) Reindex(source, dest string,
+	opts ...ReindexOption) (*ByQueryResponse, error) {
+
+	r := newByQueryRequest()
+	for _, opt := range opts {
+		opt.applyReindex(r)
+	}
+	r.source["index"] = source
+	r.body["source"] = r.source
+	r.body["dest"] = map[string]string{"index": dest}
+	// Unlike _delete_by_query, _reindex only takes this in the body.
+	if mode, ok := r.params["conflicts"]; ok {
+		r.body["conflicts"] = mode
+		delete(r.params, "conflicts")
+	}
+
+	u := es.url("_reindex")
+	updateUrlQuery(u, r.params)
+
+	rv := &ByQueryResponse{}
+	_, err := es.call("POST", u.String(), r.body, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
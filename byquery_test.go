@@ -0,0 +1,37 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReindexBody(t *testing.T) {
+	var query string
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	es := newTestClient(t, srv.URL)
+	_, err := es.Reindex("a", "b", Conflicts("proceed"), WaitForCompletion(true),
+		ReindexQuery(map[string]interface{}{"match_all": map[string]interface{}{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query != "wait_for_completion=true" {
+		t.Errorf("Query = %q, want only wait_for_completion", query)
+	}
+	if body["conflicts"] != "proceed" {
+		t.Errorf("Body conflicts = %v, want proceed", body["conflicts"])
+	}
+	source, _ := body["source"].(map[string]interface{})
+	if source["index"] != "a" || source["query"] == nil {
+		t.Errorf("Body source = %v", source)
+	}
+}
@@ -0,0 +1,104 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Health of the cluster as reported by /_cluster/health.
+type ClusterHealth struct {
+	ClusterName         string `json:"cluster_name"`
+	Status              string `json:"status"`
+	TimedOut            bool   `json:"timed_out"`
+	NumberOfNodes       int    `json:"number_of_nodes"`
+	NumberOfDataNodes   int    `json:"number_of_data_nodes"`
+	ActivePrimaryShards int    `json:"active_primary_shards"`
+	ActiveShards        int    `json:"active_shards"`
+	RelocatingShards    int    `json:"relocating_shards"`
+	InitializingShards  int    `json:"initializing_shards"`
+	UnassignedShards    int    `json:"unassigned_shards"`
+}
+
+// Option for ClusterHealth.
+type HealthOption func(params map[string]string)
+
+// Wait until the cluster reaches at least this status: "green",
+// "yellow" or "red".
+func WaitForStatus(status string) HealthOption {
+	return func(params map[string]string) {
+		params["wait_for_status"] = status
+	}
+}
+
+// Wait until this many shards are active, or "all".
+func WaitForActiveShards(shards string) HealthOption {
+	return func(params map[string]string) {
+		params["wait_for_active_shards"] = shards
+	}
+}
+
+// How long the server should wait for the other conditions.
+func Timeout(d time.Duration) HealthOption {
+	return func(params map[string]string) {
+		params["timeout"] = formatDuration(d)
+	}
+}
+
+// Fetch the cluster's health, optionally waiting for a condition.
+//
+// If a wait times out, the current health is still returned with
+// TimedOut set rather than as an error.
+func (es *ElasticSearch) ClusterHealth(opts ...HealthOption) (*ClusterHealth, error) {
+	params := map[string]string{}
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	u := es.url("_cluster", "health")
+	updateUrlQuery(u, params)
+
+	rv := &ClusterHealth{}
+	_, err := es.callAccepting("GET", u.String(), nil, rv,
+		http.StatusRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// Result of a successful Ping.
+type PingResult struct {
+	ClusterName   string
+	ClusterUUID   string
+	VersionNumber string
+	// Round trip time of the request.
+	Latency time.Duration
+}
+
+// Check that the server is reachable and the credentials work.
+//
+// Any failure, including ctx expiring first, is returned as an error.
+func (es *ElasticSearch) Ping(ctx context.Context) (*PingResult, error) {
+	info := struct {
+		ClusterName string `json:"cluster_name"`
+		ClusterUUID string `json:"cluster_uuid"`
+		Version     struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}{}
+
+	start := time.Now()
+	_, err := es.callContext(ctx, "GET", es.url().String(), nil, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PingResult{
+		ClusterName:   info.ClusterName,
+		ClusterUUID:   info.ClusterUUID,
+		VersionNumber: info.Version.Number,
+		Latency:       time.Since(start),
+	}, nil
+}
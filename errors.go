@@ -0,0 +1,142 @@
+package elasticsearch
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// One underlying cause of an ESError.
+type ESErrorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index,omitempty"`
+}
+
+// Error reported by the server in a non-2xx response.
+type ESError struct {
+	StatusCode int
+	// The server's error type, e.g. "index_not_found_exception".
+	Type      string
+	Reason    string
+	RootCause []ESErrorCause
+	// The start of the response body, up to MaxErrorBodyBytes.
+	Body string
+	// The start of the request body, if DebugRequestBodies is set.
+	RequestBody string
+}
+
+func (e *ESError) Error() string {
+	msg := http.StatusText(e.StatusCode)
+	if e.Type != "" {
+		msg += ": " + e.Type
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Type == "" && e.Reason == "" && e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if e.RequestBody != "" {
+		msg += " (request: " + e.RequestBody + ")"
+	}
+	return msg
+}
+
+// Default limit on the body text kept in an ESError.
+const DefaultMaxErrorBodyBytes = 1024
+
+// Cut s down to the configured error body size.
+func (es *ElasticSearch) errorSnippet(s []byte) string {
+	limit := es.MaxErrorBodyBytes
+	if limit == 0 {
+		limit = DefaultMaxErrorBodyBytes
+	}
+	if limit < 0 {
+		return ""
+	}
+	if len(s) > limit {
+		return string(s[:limit]) + "..."
+	}
+	return string(s)
+}
+
+// Build an ESError from a failed response, consuming its body.
+func (es *ElasticSearch) newESError(resp *http.Response) *ESError {
+	rv := &ESError{StatusCode: resp.StatusCode}
+
+	if es.DebugRequestBodies && resp.Request != nil && resp.Request.GetBody != nil {
+		if reqBody, err := requestBody(resp.Request); err == nil {
+			rv.RequestBody = es.errorSnippet(reqBody)
+		}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(body) == 0 {
+		return rv
+	}
+	rv.Body = es.errorSnippet(body)
+
+	var wrapper struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &wrapper) == nil {
+		rv.parse(wrapper.Error)
+	}
+	return rv
+}
+
+// Read a copy of a request's body, decompressed if need be.
+func requestBody(req *http.Request) ([]byte, error) {
+	rb, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rb.Close()
+
+	var r io.Reader = rb
+	if cr, ok := rb.(*countingReader); ok {
+		// This copy isn't sent, so leave it out of BytesSent.
+		r = cr.ReadCloser
+	}
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	}
+	return ioutil.ReadAll(r)
+}
+
+// Fill in the details from the value of an "error" field.
+func (e *ESError) parse(raw json.RawMessage) {
+	if len(raw) == 0 {
+		return
+	}
+
+	// Old servers report the error as a plain string.
+	if json.Unmarshal(raw, &e.Reason) == nil {
+		return
+	}
+
+	var detail struct {
+		Type      string         `json:"type"`
+		Reason    string         `json:"reason"`
+		RootCause []ESErrorCause `json:"root_cause"`
+	}
+	if json.Unmarshal(raw, &detail) == nil {
+		e.Type = detail.Type
+		e.Reason = detail.Reason
+		e.RootCause = detail.RootCause
+	}
+}
+
+// Whether err is, or wraps, an ESError of the given type.
+func IsESErrorType(err error, errType string) bool {
+	var esErr *ESError
+	return errors.As(err, &esErr) && esErr.Type == errType
+}
@@ -3,35 +3,102 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
+	// Deprecated: no longer returned.  Failed requests return an
+	// *ESError instead.
 	ResponseError = errors.New("Response wasn't OK")
+	ClientClosed  = errors.New("Client has been closed")
 )
 
 const (
 	JSON_MIME = "application/json"
 )
 
+// Destination for client diagnostics.  *log.Logger satisfies this.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+func defaultLogger() Logger {
+	return log.New(os.Stderr, "elasticsearch: ", log.LstdFlags)
+}
+
 // Reference to an ElasticSearch server.
 type ElasticSearch struct {
+	// Credentials for HTTP basic authentication, if Username isn't
+	// empty.
+	Username string
+	Password string
+	// API key sent as "Authorization: ApiKey ...".  This takes
+	// precedence over basic auth.
+	APIKey string
+
+	// How long a host that refused a connection is skipped before
+	// being tried again.  Zero means DefaultDeadHostCooldown.
+	DeadHostCooldown time.Duration
+	// Periodically ask the cluster for its nodes and send requests
+	// to those instead of the configured hosts.  Sniffing starts
+	// with the first request.
+	Sniff bool
+	// How often to sniff.  Zero means DefaultSniffInterval.
+	SniffInterval time.Duration
+	// How much of a failed request's response body to keep in the
+	// ESError.  Zero means DefaultMaxErrorBodyBytes and a negative
+	// value keeps none.
+	MaxErrorBodyBytes int
+	// Also keep the start of the request body in each ESError.
+	DebugRequestBodies bool
+	// Path under which the server's API is found, for clusters
+	// behind a proxy, e.g. "/es/".  NewWithClient and NewWithHosts
+	// take this from the path of the (first) base URL.
+	PathPrefix string
+	// Called with every response the client receives, including
+	// those to bulk requests, before the body is read.  The hook
+	// may inspect headers but must not read or close the body.
+	OnResponse func(*http.Response)
+	// Time limit for each request whose context has no deadline of
+	// its own, including reading the response.  Zero means no
+	// limit.
+	DefaultTimeout time.Duration
+	// Where diagnostics are written.  Constructors set this to the
+	// standard logger; nil means silent.
+	Logger Logger
+
 	client *http.Client
+	// Scheme and host used when building URLs.  The host pool
+	// may send the request elsewhere.
+	scheme string
 	host   string
-}
+	hosts  *hostPool
+
+	sniffOnce     sync.Once
+	sniffStop     chan struct{}
+	sniffStopOnce sync.Once
 
-type response struct {
-	OK     bool                   `json:"ok"`
-	Index  string                 `json:"_index"`
-	Type   string                 `json:"_type"`
-	Id     string                 `json:"_id"`
-	Found  bool                   `json:"found"`
-	Source map[string]interface{} `json:"_source"`
+	warnMu   sync.Mutex
+	warnings []string
+	warnSeen map[string]bool
+
+	// Bulk updaters to shut down on Close.
+	writersMu sync.Mutex
+	writers   map[*bulkWriter]bool
+	closed    atomic.Bool
 }
 
 func NewElasticSearch(host string, maxConns int) *ElasticSearch {
@@ -45,16 +112,182 @@ func NewElasticSearch(host string, maxConns int) *ElasticSearch {
 
 	return &ElasticSearch{
 		client: client,
+		scheme: "http",
 		host:   host,
+		hosts:  newHostPool([]poolHost{{scheme: "http", host: host}}),
+		Logger: defaultLogger(),
+	}
+}
+
+// Get a reference to a server using the given HTTP client.
+//
+// baseURL may be a full URL such as "https://es.example.com:9200" or
+// just a host:port, in which case http is assumed.  Any path in the
+// URL becomes the PathPrefix.  If client is nil,
+// http.DefaultClient is used.
+func NewWithClient(baseURL string, client *http.Client) (*ElasticSearch, error) {
+	return NewWithHosts([]string{baseURL}, client)
+}
+
+// Get a reference to a cluster reachable through several hosts.
+//
+// Requests are spread across the hosts round-robin.  A host that
+// refuses a connection is skipped for DeadHostCooldown and the
+// request is retried on the next one.  Each entry is interpreted as
+// by NewWithClient.
+func NewWithHosts(baseURLs []string, client *http.Client) (*ElasticSearch, error) {
+	if len(baseURLs) == 0 {
+		return nil, errors.New("No hosts given")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	hosts := make([]poolHost, len(baseURLs))
+	var prefix string
+	for i, baseURL := range baseURLs {
+		h, path, err := parseHost(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		hosts[i] = h
+		if i == 0 {
+			prefix = path
+		}
+	}
+
+	return &ElasticSearch{
+		PathPrefix: prefix,
+		client:     client,
+		scheme:     hosts[0].scheme,
+		host:       hosts[0].host,
+		hosts:      newHostPool(hosts),
+		Logger:     defaultLogger(),
+	}, nil
+}
+
+func (es *ElasticSearch) logf(format string, args ...interface{}) {
+	if es.Logger != nil {
+		es.Logger.Printf(format, args...)
+	}
+}
+
+// Split a base URL into its host and any path prefix.
+func parseHost(baseURL string) (poolHost, string, error) {
+	if !strings.Contains(baseURL, "://") {
+		return poolHost{scheme: "http", host: baseURL}, "", nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return poolHost{}, "", err
+	}
+	return poolHost{scheme: u.Scheme, host: u.Host}, u.Path, nil
+}
+
+// Replace the HTTP client used for all requests.
+//
+// A nil client resets to http.DefaultClient.
+func (es *ElasticSearch) SetClient(client *http.Client) {
+	if client == nil {
+		client = http.DefaultClient
 	}
+	es.client = client
+}
+
+// Use config for TLS connections, e.g. to trust a private CA with
+// RootCAs or to present a client certificate with Certificates.
+//
+// The current client is copied with a transport that uses config;
+// callers that supplied their own non-standard RoundTripper should
+// configure TLS on it instead.  InsecureSkipVerify disables
+// certificate checks entirely and is only fit for development.
+func (es *ElasticSearch) SetTLSConfig(config *tls.Config) error {
+	var transport *http.Transport
+	switch t := es.client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return fmt.Errorf("Can't set TLS config on a %T", t)
+	}
+	transport.TLSClientConfig = config
+
+	client := *es.client
+	client.Transport = transport
+	es.client = &client
+	return nil
+}
+
+// Shut down the client.
+//
+// Each bulk updater from Bulk that is still running is shut down
+// with Quit, sending its pending batch, and the first error from
+// those is returned.  Then sniffing stops, idle connections are
+// closed, and every later request fails with ClientClosed.
+func (es *ElasticSearch) Close() error {
+	es.writersMu.Lock()
+	writers := es.writers
+	es.writers = nil
+	es.writersMu.Unlock()
+
+	var rv error
+	for b := range writers {
+		if err := b.Quit(); err != nil && rv == nil {
+			rv = err
+		}
+	}
+
+	es.closed.Store(true)
+	es.StopSniffing()
+	es.client.CloseIdleConnections()
+	return rv
 }
 
 func (es *ElasticSearch) url(parts ...string) *url.URL {
+	path := strings.Join(parts, "/")
+	if prefix := strings.Trim(es.PathPrefix, "/"); prefix != "" {
+		path = prefix + "/" + path
+	}
+
 	return &url.URL{
-		Scheme: "http",
+		Scheme: es.scheme,
 		Host:   es.host,
-		Path:   strings.Join(parts, "/"),
+		Path:   path,
+	}
+}
+
+// The type part of a document path.  Servers without mapping types
+// use _doc.
+func docType(doctype string) string {
+	if doctype == "" {
+		return "_doc"
+	}
+	return doctype
+}
+
+// Build a request with the standard headers and credentials applied.
+func (es *ElasticSearch) newRequest(ctx context.Context, method, u string,
+	body io.Reader) (*http.Request, error) {
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", JSON_MIME)
+	}
+
+	switch {
+	case es.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+es.APIKey)
+	case es.Username != "":
+		req.SetBasicAuth(es.Username, es.Password)
 	}
+
+	return req, nil
 }
 
 func updateUrlQuery(u *url.URL, params map[string]string) {
@@ -62,103 +295,275 @@ func updateUrlQuery(u *url.URL, params map[string]string) {
 	for key, value := range params {
 		query.Add(key, value)
 	}
+	u.RawQuery = query.Encode()
 }
 
-func handleResponse(resp *http.Response) (*response, error) {
-	defer resp.Body.Close()
+// Send a request with an optional JSON body and decode the JSON reply.
+//
+// data and dst may each be nil.  Returns the HTTP status code, which
+// is always 2xx when the error is nil.
+func (es *ElasticSearch) call(method, u string, data, dst interface{}) (int, error) {
+	return es.callContext(context.Background(), method, u, data, dst)
+}
+
+// Like call, but also treat the given non-2xx statuses as success.
+func (es *ElasticSearch) callAccepting(method, u string, data, dst interface{},
+	accept ...int) (int, error) {
+
+	return es.callContext(context.Background(), method, u, data, dst, accept...)
+}
 
-	if resp.StatusCode > 299 || resp.StatusCode < 200 {
-		return nil, errors.New(resp.Status)
+// Like callAccepting, with a context governing the request.
+func (es *ElasticSearch) callContext(ctx context.Context, method, u string,
+	data, dst interface{}, accept ...int) (int, error) {
+
+	var body []byte
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return 0, err
+		}
+		body = encoded
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	return es.callRaw(ctx, method, u, body, dst, accept...)
+}
+
+// Like callContext, with a pre-encoded request body.
+func (es *ElasticSearch) callRaw(ctx context.Context, method, u string,
+	data []byte, dst interface{}, accept ...int) (int, error) {
+
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
 	}
 
-	data := &response{}
+	req, err := es.newRequest(ctx, method, u, body)
+	if err != nil {
+		return 0, err
+	}
 
-	err = json.Unmarshal(body, data)
+	resp, err := es.do(req)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	if !data.OK {
-		return nil, ResponseError
+	if !statusOK(resp.StatusCode, accept) {
+		return resp.StatusCode, es.newESError(resp)
 	}
 
-	return data, nil
+	if dst != nil {
+		err = json.NewDecoder(resp.Body).Decode(dst)
+		if err != nil {
+			return resp.StatusCode, err
+		}
+	}
+
+	return resp.StatusCode, nil
 }
 
-func (es *ElasticSearch) post(u string, data interface{}) (*response, error) {
-	body, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
+func statusOK(status int, accept []int) bool {
+	if status >= 200 && status <= 299 {
+		return true
 	}
+	for _, a := range accept {
+		if status == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Result of indexing a single document.
+type IndexResponse struct {
+	Index       string `json:"_index"`
+	Type        string `json:"_type"`
+	Id          string `json:"_id"`
+	Version     int64  `json:"_version"`
+	Result      string `json:"result"`
+	SeqNo       int64  `json:"_seq_no"`
+	PrimaryTerm int64  `json:"_primary_term"`
+	// True if the document was newly created (HTTP 201) rather
+	// than replacing an existing one (HTTP 200).
+	Created bool `json:"-"`
+}
 
-	resp, err := es.client.Post(u, JSON_MIME, bytes.NewBuffer(body))
+// Store a document in the index.
+//
+// The ID is optional in which case the ID will be generated by the
+// server.  An empty doctype means _doc.
+//
+// Returns the server's description of the stored document on
+// success, otherwise an error.
+func (es *ElasticSearch) Index(index, doctype, id string,
+	doc interface{}, params map[string]string) (*IndexResponse, error) {
+
+	method := "PUT"
+	u := es.url(index, docType(doctype), id)
+	if id == "" {
+		method = "POST"
+		u = es.url(index, docType(doctype))
+	}
+	updateUrlQuery(u, params)
+
+	rv := &IndexResponse{}
+	status, err := es.call(method, u.String(), doc, rv)
 	if err != nil {
 		return nil, err
 	}
 
-	return handleResponse(resp)
+	rv.Created = status == http.StatusCreated
+	return rv, nil
+}
+
+// Result of fetching a single document.
+type GetResponse struct {
+	Index       string          `json:"_index"`
+	Type        string          `json:"_type"`
+	Id          string          `json:"_id"`
+	Found       bool            `json:"found"`
+	Version     int64           `json:"_version"`
+	SeqNo       int64           `json:"_seq_no"`
+	PrimaryTerm int64           `json:"_primary_term"`
+	Source      json.RawMessage `json:"_source"`
 }
 
-func (es *ElasticSearch) delete(u string) (*response, error) {
-	req, err := http.NewRequest("DELETE", u, nil)
+// Fetch a document by ID.
+//
+// If the document exists, its source is unmarshaled into dst (which
+// may be nil to skip that).  A missing document is not an error; it
+// is reported with Found set to false.  An empty doctype means _doc.
+func (es *ElasticSearch) Get(index, doctype, id string, dst interface{},
+	params map[string]string) (*GetResponse, error) {
+
+	u := es.url(index, docType(doctype), id)
+	updateUrlQuery(u, params)
+
+	rv := &GetResponse{}
+	status, err := es.call("GET", u.String(), nil, rv)
+	if status == http.StatusNotFound {
+		return &GetResponse{Index: index, Type: doctype, Id: id}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := es.client.Do(req)
-	if err != nil {
-		return nil, err
+	if rv.Found && dst != nil && len(rv.Source) > 0 {
+		err = json.Unmarshal(rv.Source, dst)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return handleResponse(resp)
+	return rv, nil
 }
 
-func (es *ElasticSearch) CreateIndex(index string, settings interface{},
-	params map[string]string) error {
+// Check whether a document exists without fetching its source.  An
+// empty doctype means _doc.
+func (es *ElasticSearch) Exists(index, doctype, id string,
+	params map[string]string) (bool, error) {
 
-	u := es.url(index)
+	u := es.url(index, docType(doctype), id)
 	updateUrlQuery(u, params)
 
-	_, err := es.post(u.String(), settings)
-	return err
+	status, err := es.call("HEAD", u.String(), nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// Store a document in the index.
-//
-// The ID is optional in which case the ID will be generated by the
-// server.
-//
-// Returns the new ID on success, otherwise an error.
-func (es *ElasticSearch) Index(index, doctype, id string,
-	doc interface{}, params map[string]string) (string, error) {
+// One document in a MultiGetResponse.
+type MultiGetDoc struct {
+	Index   string          `json:"_index"`
+	Type    string          `json:"_type"`
+	Id      string          `json:"_id"`
+	Found   bool            `json:"found"`
+	Version int64           `json:"_version"`
+	Source  json.RawMessage `json:"_source"`
+}
 
-	u := es.url(index, doctype, id)
-	updateUrlQuery(u, params)
+// Result of fetching several documents at once.
+type MultiGetResponse struct {
+	// One entry per requested ID, in request order.  Missing
+	// documents are included with Found set to false.
+	Docs []MultiGetDoc `json:"docs"`
+}
+
+// Fetch several documents from an index in one request.
+func (es *ElasticSearch) MultiGet(index string, ids []string) (*MultiGetResponse, error) {
+	rv := &MultiGetResponse{}
+	_, err := es.call("POST", es.url(index, "_mget").String(),
+		map[string][]string{"ids": ids}, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
 
-	resp, err := es.post(u.String(), doc)
+// Check which of several documents exist, without fetching their
+// sources.
+//
+// Every id is present in the result, mapped to whether it exists.
+func (es *ElasticSearch) ExistsBatch(index string, ids []string) (map[string]bool, error) {
+	u := es.url(index, "_mget")
+	updateUrlQuery(u, map[string]string{"_source": "false"})
+
+	resp := &MultiGetResponse{}
+	_, err := es.call("POST", u.String(), map[string][]string{"ids": ids}, resp)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	rv := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		rv[id] = false
+	}
+	for _, doc := range resp.Docs {
+		if doc.Found {
+			rv[doc.Id] = true
+		}
 	}
+	return rv, nil
+}
 
-	return resp.Id, nil
+// Result of deleting a single document.
+type DeleteResponse struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type"`
+	Id    string `json:"_id"`
+	// "deleted", or "not_found" if there was no such document.
+	Result      string `json:"result"`
+	Version     int64  `json:"_version"`
+	SeqNo       int64  `json:"_seq_no"`
+	PrimaryTerm int64  `json:"_primary_term"`
 }
 
-// Delete an index entry.
+// Delete a document by ID.
+//
+// A missing document is not an error; it is reported with Result
+// set to "not_found".  An empty doctype means _doc.
 func (es *ElasticSearch) Delete(index, doctype, id string,
-	params map[string]string) (bool, error) {
+	params map[string]string) (*DeleteResponse, error) {
 
-	u := es.url(index, doctype, id)
+	u := es.url(index, docType(doctype), id)
 	updateUrlQuery(u, params)
 
-	resp, err := es.delete(u.String())
+	rv := &DeleteResponse{}
+	status, err := es.callAccepting("DELETE", u.String(), nil, rv,
+		http.StatusNotFound)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return resp.Found, nil
+	if status == http.StatusNotFound {
+		// A missing index gets an error body rather than a result.
+		rv.Index, rv.Type, rv.Id = index, doctype, id
+		rv.Result = "not_found"
+	}
+	return rv, nil
 }
@@ -0,0 +1,92 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Server recording the method and path of each request and answering
+// with an empty JSON object.
+type testPathServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	paths []string
+}
+
+func newTestPathServer(t *testing.T) *testPathServer {
+	s := &testPathServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testPathServer) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.paths) == 0 {
+		return ""
+	}
+	return s.paths[len(s.paths)-1]
+}
+
+func TestTypelessDocumentPaths(t *testing.T) {
+	srv := newTestPathServer(t)
+	es := newTestClient(t, srv.URL)
+	doc := map[string]interface{}{"n": 1}
+
+	tests := []struct {
+		call func() error
+		want string
+	}{
+		{func() error { _, err := es.Index("idx", "", "1", doc, nil); return err },
+			"PUT /idx/_doc/1"},
+		{func() error { _, err := es.Index("idx", "", "", doc, nil); return err },
+			"POST /idx/_doc"},
+		{func() error { _, err := es.Index("idx", "tweet", "1", doc, nil); return err },
+			"PUT /idx/tweet/1"},
+		{func() error { _, err := es.Get("idx", "", "1", nil, nil); return err },
+			"GET /idx/_doc/1"},
+		{func() error { _, err := es.Exists("idx", "", "1", nil); return err },
+			"HEAD /idx/_doc/1"},
+		{func() error { _, err := es.Delete("idx", "", "1", nil); return err },
+			"DELETE /idx/_doc/1"},
+	}
+	for _, test := range tests {
+		if err := test.call(); err != nil {
+			t.Fatal(err)
+		}
+		if got := srv.last(); got != test.want {
+			t.Errorf("Request = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestStopSniffingConcurrently(t *testing.T) {
+	srv := newTestPathServer(t)
+	es := newTestClient(t, srv.URL)
+	es.Sniff = true
+	if _, err := es.Exists("idx", "", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			es.StopSniffing()
+		}()
+		go func() {
+			defer wg.Done()
+			es.Close()
+		}()
+	}
+	wg.Wait()
+}
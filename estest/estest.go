@@ -0,0 +1,201 @@
+// Fake elasticsearch bulk endpoint for testing code that uses the
+// elasticsearch package.
+package estest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// One instruction received by a BulkServer.
+type Action struct {
+	// The action name, e.g. "index" or "delete".
+	Type  string
+	Index string
+	Id    string
+	// The full action metadata.
+	Meta map[string]interface{}
+	// The source line, or nil for a delete.
+	Source json.RawMessage
+}
+
+// The instructions received in one bulk request.
+type Batch struct {
+	Actions []Action
+}
+
+// Canned failure for an item, set with BulkServer.FailItem.
+type itemFailure struct {
+	status  int
+	errType string
+	reason  string
+}
+
+// An HTTP server that accepts bulk requests, records them and
+// answers each item with success unless told otherwise.
+type BulkServer struct {
+	// Base URL of the server, for elasticsearch.NewWithClient.
+	URL string
+	// The client's PathPrefix, if any.  Set it when the client
+	// sends to a path like /prefix/_bulk so that "prefix" isn't
+	// taken for an index name.
+	PathPrefix string
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	batches  []Batch
+	failures map[string]itemFailure
+	nextId   int
+}
+
+// Start a BulkServer.  Callers should Close it when done.
+func NewBulkServer() *BulkServer {
+	s := &BulkServer{failures: map[string]itemFailure{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Shut down the server.
+func (s *BulkServer) Close() {
+	s.srv.Close()
+}
+
+// Answer every later item for the document with the given id with
+// this status and error.
+func (s *BulkServer) FailItem(id string, status int, errType, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[id] = itemFailure{status, errType, reason}
+}
+
+// The batches received so far, in order.
+func (s *BulkServer) Batches() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Batch(nil), s.batches...)
+}
+
+// Every action received so far, across all batches.
+func (s *BulkServer) Actions() []Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rv []Action
+	for _, b := range s.batches {
+		rv = append(rv, b.Actions...)
+	}
+	return rv
+}
+
+func (s *BulkServer) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		http.Error(w, `{"error":"not a bulk request"}`, http.StatusNotFound)
+		return
+	}
+
+	// The index of an index-scoped bulk URL, e.g. /myindex/_bulk.
+	path := strings.TrimPrefix(r.URL.Path, "/"+strings.Trim(s.PathPrefix, "/"))
+	path = strings.Trim(strings.TrimSuffix(path, "_bulk"), "/")
+	urlIndex := path[strings.LastIndex(path, "/")+1:]
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()),
+				http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	batch, err := parseBatch(body, urlIndex)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()),
+			http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	items := make([]map[string]interface{}, len(batch.Actions))
+	errors := false
+	for i := range batch.Actions {
+		a := &batch.Actions[i]
+		if a.Id == "" {
+			s.nextId++
+			a.Id = fmt.Sprintf("generated-%d", s.nextId)
+		}
+
+		result := map[string]interface{}{
+			"_index": a.Index,
+			"_id":    a.Id,
+			"status": http.StatusOK,
+		}
+		if a.Type == "create" {
+			result["status"] = http.StatusCreated
+		}
+		if f, ok := s.failures[a.Id]; ok {
+			result["status"] = f.status
+			result["error"] = map[string]string{
+				"type":   f.errType,
+				"reason": f.reason,
+			}
+			errors = true
+		}
+		items[i] = map[string]interface{}{a.Type: result}
+	}
+	s.batches = append(s.batches, batch)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"took":   1,
+		"errors": errors,
+		"items":  items,
+	})
+}
+
+// Read the NDJSON body of a bulk request.
+func parseBatch(body io.Reader, urlIndex string) (Batch, error) {
+	var batch Batch
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 100<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(line, &action); err != nil {
+			return batch, fmt.Errorf("bad action line: %v", err)
+		}
+		for name, meta := range action {
+			a := Action{Type: name, Meta: meta, Index: urlIndex}
+			if index, ok := meta["_index"].(string); ok {
+				a.Index = index
+			}
+			if id, ok := meta["_id"].(string); ok {
+				a.Id = id
+			}
+
+			if name != "delete" {
+				if !scanner.Scan() {
+					return batch, fmt.Errorf("%s action has no source line", name)
+				}
+				a.Source = append(json.RawMessage(nil), scanner.Bytes()...)
+			}
+			batch.Actions = append(batch.Actions, a)
+		}
+	}
+	return batch, scanner.Err()
+}
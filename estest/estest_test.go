@@ -0,0 +1,59 @@
+package estest_test
+
+import (
+	"testing"
+
+	elasticsearch "github.com/wtolson/go-elasticsearch"
+	"github.com/wtolson/go-elasticsearch/estest"
+)
+
+func TestBulkServer(t *testing.T) {
+	srv := estest.NewBulkServer()
+	defer srv.Close()
+	srv.PathPrefix = "es"
+	srv.FailItem("2", 409, "version_conflict_engine_exception", "conflict")
+
+	es, err := elasticsearch.NewWithClient(srv.URL+"/es", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := es.Bulk(&elasticsearch.BulkOptions{Index: "myindex", Compress: true})
+	defer b.Quit()
+
+	for _, id := range []string{"1", "2"} {
+		err := b.Update(&elasticsearch.IndexInstruction{Id: id,
+			Index: "myindex", Body: map[string]interface{}{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Update(&elasticsearch.DeleteInstruction{Id: "3", Index: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	rv, err := b.SendBatch()
+	if err != elasticsearch.BulkItemsFailed {
+		t.Fatalf("SendBatch() error = %v, want BulkItemsFailed", err)
+	}
+	if failed := rv.Failed(); len(failed) != 1 || failed[0].Id != "2" {
+		t.Errorf("Failed items = %+v, want just 2", failed)
+	}
+
+	want := []estest.Action{
+		{Type: "index", Index: "myindex", Id: "1"},
+		{Type: "index", Index: "myindex", Id: "2"},
+		{Type: "delete", Index: "other", Id: "3"},
+	}
+	got := srv.Actions()
+	if len(got) != len(want) {
+		t.Fatalf("Got %d actions, want %d", len(got), len(want))
+	}
+	for i, a := range got {
+		if a.Type != want[i].Type || a.Index != want[i].Index || a.Id != want[i].Id {
+			t.Errorf("Action %d = %s %s/%s, want %s %s/%s", i,
+				a.Type, a.Index, a.Id, want[i].Type, want[i].Index, want[i].Id)
+		}
+	}
+	if src := string(got[0].Source); src != `{"id":"1"}` {
+		t.Errorf("Action 0 source = %s", src)
+	}
+}
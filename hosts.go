@@ -0,0 +1,197 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default time a host is skipped after refusing a connection.
+const DefaultDeadHostCooldown = 30 * time.Second
+
+type poolHost struct {
+	scheme string
+	host   string
+	// Zero while the host is believed to be up.
+	deadUntil time.Time
+}
+
+// Round-robin set of hosts for one cluster.
+type hostPool struct {
+	mu    sync.Mutex
+	hosts []poolHost
+	next  int
+}
+
+func newHostPool(hosts []poolHost) *hostPool {
+	return &hostPool{hosts: hosts}
+}
+
+// Pick the next live host, returning its index.
+//
+// If every host is dead, the one that has been dead longest is tried
+// anyway rather than failing outright.
+func (p *hostPool) pick(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.hosts)
+	best := -1
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		h := &p.hosts[idx]
+		if !now.Before(h.deadUntil) {
+			p.next = idx + 1
+			return idx
+		}
+		if best < 0 || h.deadUntil.Before(p.hosts[best].deadUntil) {
+			best = idx
+		}
+	}
+	p.next = best + 1
+	return best
+}
+
+func (p *hostPool) get(idx int) poolHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hosts[idx]
+}
+
+func (p *hostPool) markDead(idx int, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts[idx].deadUntil = until
+}
+
+func (p *hostPool) markAlive(idx int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts[idx].deadUntil = time.Time{}
+}
+
+// Replace the set of hosts, keeping the state of any that remain.
+func (p *hostPool) replace(hosts []poolHost) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := map[string]poolHost{}
+	for _, h := range p.hosts {
+		old[h.scheme+"://"+h.host] = h
+	}
+	for i, h := range hosts {
+		if prev, ok := old[h.scheme+"://"+h.host]; ok {
+			hosts[i] = prev
+		}
+	}
+
+	p.hosts = hosts
+	if p.next >= len(hosts) {
+		p.next = 0
+	}
+}
+
+func (p *hostPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.hosts)
+}
+
+// Whether err means the host couldn't be reached at all, so the
+// request never got there and is safe to send elsewhere.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (es *ElasticSearch) cooldown() time.Duration {
+	if es.DeadHostCooldown == 0 {
+		return DefaultDeadHostCooldown
+	}
+	return es.DeadHostCooldown
+}
+
+// Send a request to the next live host, failing over to the others
+// if it can't be reached.
+func (es *ElasticSearch) do(req *http.Request) (*http.Response, error) {
+	if es.closed.Load() {
+		return nil, ClientClosed
+	}
+	if es.Sniff {
+		es.sniffOnce.Do(es.startSniffing)
+	}
+
+	var cancel context.CancelFunc
+	if es.DefaultTimeout > 0 {
+		if _, ok := req.Context().Deadline(); !ok {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(req.Context(), es.DefaultTimeout)
+			req = req.WithContext(ctx)
+		}
+	}
+
+	var lastErr error
+
+	tries := es.hosts.size()
+	for i := 0; i < tries; i++ {
+		idx := es.hosts.pick(time.Now())
+		h := es.hosts.get(idx)
+
+		r := req.Clone(req.Context())
+		r.URL.Scheme = h.scheme
+		r.URL.Host = h.host
+		r.Host = h.host
+		if i > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				break
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				lastErr = err
+				break
+			}
+			r.Body = body
+		}
+
+		resp, err := es.client.Do(r)
+		if err == nil {
+			es.hosts.markAlive(idx)
+			es.noteWarnings(resp)
+			if es.OnResponse != nil {
+				es.OnResponse(resp)
+			}
+			if cancel != nil {
+				resp.Body = &cancelBody{resp.Body, cancel}
+			}
+			return resp, nil
+		}
+
+		lastErr = err
+		if !isDialError(err) || req.Context().Err() != nil {
+			break
+		}
+		es.logf("Marking %s dead for %v: %v", h.host, es.cooldown(), err)
+		es.hosts.markDead(idx, time.Now().Add(es.cooldown()))
+	}
+
+	if cancel != nil {
+		cancel()
+	}
+	return nil, lastErr
+}
+
+// Response body that releases the request's timeout when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
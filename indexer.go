@@ -0,0 +1,102 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// Bulk indexer for documents of a single Go type.
+//
+// Documents are marshaled directly, so struct json tags are
+// respected and no intermediate map is built.  Batching, flushing and
+// shutdown behave exactly as for the embedded BulkUpdater.
+type BulkIndexer[T any] struct {
+	BulkUpdater
+	index   string
+	doctype string
+}
+
+// Get a typed bulk indexer writing to the given index and type.
+//
+// opts is as for Bulk.
+func NewBulkIndexer[T any](es *ElasticSearch, index, doctype string,
+	opts *BulkOptions) *BulkIndexer[T] {
+
+	return &BulkIndexer[T]{
+		BulkUpdater: es.Bulk(opts),
+		index:       index,
+		doctype:     doctype,
+	}
+}
+
+// Queue a document to be indexed.
+//
+// An empty id lets the server generate one.  Returns BulkClosed
+// after Quit.
+func (bi *BulkIndexer[T]) Add(id string, doc T) error {
+	return bi.Update(&typedIndexInstruction[T]{
+		meta: IndexInstruction{
+			Id:    id,
+			Index: bi.index,
+			Type:  bi.doctype,
+		},
+		doc: doc,
+	})
+}
+
+type typedIndexInstruction[T any] struct {
+	meta IndexInstruction
+	doc  T
+}
+
+func (ti *typedIndexInstruction[T]) validate() error {
+	return ti.meta.validate()
+}
+
+func (ti *typedIndexInstruction[T]) withDefaults(d instructionDefaults) Instruction {
+	c := *ti
+	c.meta.Index, c.meta.Routing, c.meta.Type = d.apply(c.meta.Index, c.meta.Routing, c.meta.Type)
+	return &c
+}
+
+func (ti *typedIndexInstruction[T]) writeTo(e encoder) error {
+	err := e.Encode(map[string]interface{}{
+		"index": &ti.meta,
+	})
+	if err != nil {
+		return err
+	}
+	err = e.Encode(ti.doc)
+	return err
+}
+
+// A document that knows its own ID, for BulkIndex.
+type IndexableDoc interface {
+	// The document's ID, or empty to let the server generate one.
+	ID() string
+	// The value to marshal as the document's source.
+	Document() interface{}
+}
+
+// Index a slice of documents in as few bulk requests as possible.
+//
+// The requests are split by DefaultBulkMaxBytes, and their responses
+// merged, as for BulkRaw.
+func (es *ElasticSearch) BulkIndex(index string, docs []IndexableDoc) (*BulkResponse, error) {
+	buf := &bytes.Buffer{}
+	e := json.NewEncoder(buf)
+	for _, doc := range docs {
+		ins := &typedIndexInstruction[interface{}]{
+			meta: IndexInstruction{Id: doc.ID(), Index: index},
+			doc:  doc.Document(),
+		}
+		if err := ins.validate(); err != nil {
+			return nil, err
+		}
+		if err := ins.writeTo(e); err != nil {
+			return nil, err
+		}
+	}
+	return es.BulkRaw(context.Background(), buf, nil)
+}
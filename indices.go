@@ -0,0 +1,92 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Check whether an index (or alias) exists.
+func (es *ElasticSearch) IndexExists(index string) (bool, error) {
+	status, err := es.call("HEAD", es.url(index).String(), nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Create an index.
+//
+// settings is the request body, which may carry "settings" and
+// "mappings", or be nil.  If the index already exists, the error is
+// an *ESError with Type "resource_already_exists_exception".
+func (es *ElasticSearch) CreateIndex(index string, settings interface{},
+	params map[string]string) error {
+
+	u := es.url(index)
+	updateUrlQuery(u, params)
+
+	_, err := es.call("PUT", u.String(), settings, nil)
+	return err
+}
+
+// Delete an index and all of its documents.
+func (es *ElasticSearch) DeleteIndex(index string) error {
+	_, err := es.call("DELETE", es.url(index).String(), nil, nil)
+	return err
+}
+
+// Add or update field mappings on an index.
+//
+// An incompatible change, such as changing a field's type, fails
+// with an *ESError of Type "illegal_argument_exception".
+func (es *ElasticSearch) PutMapping(index string, mapping interface{}) error {
+	_, err := es.call("PUT", es.url(index, "_mapping").String(), mapping, nil)
+	return err
+}
+
+// Fetch the mappings of an index, keyed by index name.
+func (es *ElasticSearch) GetMapping(index string) (json.RawMessage, error) {
+	var rv json.RawMessage
+	_, err := es.call("GET", es.url(index, "_mapping").String(), nil, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Fetch the settings of an index, keyed by index name.
+func (es *ElasticSearch) GetSettings(index string) (json.RawMessage, error) {
+	var rv json.RawMessage
+	_, err := es.call("GET", es.url(index, "_settings").String(), nil, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Change dynamic settings of an open index, such as
+// {"index": {"refresh_interval": "-1"}}.
+//
+// Static settings can only be changed on a closed index, and fail
+// with an *ESError otherwise.
+func (es *ElasticSearch) UpdateSettings(index string, settings interface{}) error {
+	_, err := es.call("PUT", es.url(index, "_settings").String(), settings, nil)
+	return err
+}
+
+// Make recent writes to the given indices visible to search.
+//
+// With no indices (or "_all"), every index is refreshed.
+func (es *ElasticSearch) Refresh(indices ...string) error {
+	u := es.url("_refresh")
+	if len(indices) > 0 {
+		u = es.url(strings.Join(indices, ","), "_refresh")
+	}
+
+	_, err := es.call("POST", u.String(), nil, nil)
+	return err
+}
@@ -0,0 +1,60 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"time"
+)
+
+// Open a point in time on an index, for consistent pagination with
+// search_after.
+//
+// The server keeps the point in time for keepAlive between searches.
+// Callers should ClosePIT it when done.
+func (es *ElasticSearch) OpenPIT(index string, keepAlive time.Duration) (string, error) {
+	u := es.url(index, "_pit")
+	updateUrlQuery(u, map[string]string{"keep_alive": formatDuration(keepAlive)})
+
+	rv := struct {
+		Id string `json:"id"`
+	}{}
+	_, err := es.call("POST", u.String(), nil, &rv)
+	if err != nil {
+		return "", err
+	}
+	return rv.Id, nil
+}
+
+// Release a point in time.
+//
+// One that has already expired or been closed is not an error.
+func (es *ElasticSearch) ClosePIT(pitID string) error {
+	status, err := es.call("DELETE", es.url("_pit").String(),
+		map[string]string{"id": pitID}, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Add a point in time, and the sort values of the last hit seen, to
+// a search body.
+//
+// The search must be run with an empty index, since the point in
+// time names it, and with a sort.  searchAfter may be nil for the
+// first page; after that pass the Sort of the previous page's last
+// hit.  query may be nil; the (possibly new) map is returned.
+func WithPIT(query map[string]interface{}, pitID string, keepAlive time.Duration,
+	searchAfter []interface{}) map[string]interface{} {
+
+	if query == nil {
+		query = map[string]interface{}{}
+	}
+	query["pit"] = map[string]string{
+		"id":         pitID,
+		"keep_alive": formatDuration(keepAlive),
+	}
+	if searchAfter != nil {
+		query["search_after"] = searchAfter
+	}
+	return query
+}
@@ -0,0 +1,104 @@
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Options for a BulkProcessor.
+type BulkProcessorOptions struct {
+	// Batching, retry and request options, as for Bulk.
+	BulkOptions
+	// Number of batches that may be in flight at once.  Zero
+	// means 1.
+	Workers int
+	// Called from a worker goroutine after each batch is sent,
+	// with the same results SendBatch would return.
+	OnFlush func(*BulkResponse, error)
+}
+
+// Asynchronous bulk writer.
+//
+// Instructions are batched as by Bulk, but full batches are sent by
+// a pool of background workers so Add only blocks when every worker
+// is busy.
+type BulkProcessor struct {
+	w        *bulkWriter
+	dispatch chan bulkRequest
+	onFlush  func(*BulkResponse, error)
+	wg       sync.WaitGroup
+	// Closes dispatch, so Close may be called more than once.
+	closeOnce sync.Once
+}
+
+// Start a bulk processor.
+//
+// opts may be nil to use the defaults.
+func NewBulkProcessor(es *ElasticSearch, opts *BulkProcessorOptions) *BulkProcessor {
+	if opts == nil {
+		opts = &BulkProcessorOptions{}
+	}
+
+	bp := &BulkProcessor{
+		w:        es.newBulkWriter(&opts.BulkOptions),
+		dispatch: make(chan bulkRequest),
+		onFlush:  opts.OnFlush,
+	}
+	bp.w.dispatch = bp.dispatch
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	bp.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go bp.work()
+	}
+
+	bp.w.start()
+	return bp
+}
+
+func (bp *BulkProcessor) work() {
+	defer bp.wg.Done()
+	for br := range bp.dispatch {
+		rv, _, err := bp.w.complete(br, false)
+		if bp.onFlush != nil {
+			bp.onFlush(rv, err)
+		}
+	}
+}
+
+// Queue an instruction.  Returns BulkClosed after Close.
+func (bp *BulkProcessor) Add(ins Instruction) error {
+	return bp.w.Update(ins)
+}
+
+// Snapshot of this processor's statistics.
+func (bp *BulkProcessor) Stats() BulkStats {
+	return bp.w.Stats()
+}
+
+// Send whatever is still buffered and wait for every in-flight batch
+// to finish.
+//
+// If ctx is done first, Close returns without waiting any longer;
+// the remaining batches still finish in the background.
+// Closing again is safe and returns the same error.
+func (bp *BulkProcessor) Close(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		err := bp.w.Quit()
+		bp.closeOnce.Do(func() { close(bp.dispatch) })
+		bp.wg.Wait()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("Bulk processor close aborted: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,102 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Iterator over every page of a scrolled search.
+type Scroller struct {
+	es        *ElasticSearch
+	keepAlive string
+	scrollId  string
+	// The first page, returned by the initial search.
+	first  *SearchResponse
+	done   bool
+	closed bool
+}
+
+// Format a duration in the server's time unit syntax.
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// Start a scrolled search for deep pagination.
+//
+// The server keeps the scroll context alive for keepAlive between
+// calls to Next.  Callers should always Close the Scroller when done.
+func (es *ElasticSearch) Scroll(index string, query interface{},
+	keepAlive time.Duration) (*Scroller, error) {
+
+	ka := formatDuration(keepAlive)
+	resp, err := es.Search(index, query, map[string]string{"scroll": ka})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scroller{
+		es:        es,
+		keepAlive: ka,
+		scrollId:  resp.ScrollId,
+		first:     resp,
+	}, nil
+}
+
+// Fetch the next page of results.
+//
+// The boolean is false once the scroll is exhausted, in which case
+// the response is nil.
+func (s *Scroller) Next() (*SearchResponse, bool, error) {
+	if s.done || s.closed {
+		return nil, false, nil
+	}
+
+	resp := s.first
+	s.first = nil
+	if resp == nil {
+		resp = &SearchResponse{}
+		_, err := s.es.call("POST", s.es.url("_search", "scroll").String(),
+			map[string]string{
+				"scroll":    s.keepAlive,
+				"scroll_id": s.scrollId,
+			}, resp)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if resp.ScrollId != "" {
+		s.scrollId = resp.ScrollId
+	}
+
+	if len(resp.Hits) == 0 {
+		s.done = true
+		return nil, false, nil
+	}
+
+	return resp, true, nil
+}
+
+// Release the server-side scroll context.
+//
+// It is safe to call Close more than once, and after the scroll has
+// been exhausted.
+func (s *Scroller) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.scrollId == "" {
+		return nil
+	}
+
+	status, err := s.es.call("DELETE", s.es.url("_search", "scroll").String(),
+		map[string][]string{"scroll_id": {s.scrollId}}, nil)
+	if status == http.StatusNotFound {
+		// Already expired on the server.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,339 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single document matched by a search.
+type Hit struct {
+	Index  string          `json:"_index"`
+	Type   string          `json:"_type"`
+	Id     string          `json:"_id"`
+	Score  float64         `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+	// The hit's sort values, if the search was sorted.
+	Sort []interface{} `json:"sort,omitempty"`
+	// Values asked for with WithStoredFields or WithDocvalueFields,
+	// each a JSON array.
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+	// Set if the search asked for them with WithSeqNoPrimaryTerm.
+	SeqNo       int64 `json:"_seq_no"`
+	PrimaryTerm int64 `json:"_primary_term"`
+}
+
+// A shard that failed to answer a search.
+type ShardFailure struct {
+	Shard  int          `json:"shard"`
+	Index  string       `json:"index"`
+	Node   string       `json:"node"`
+	Reason ESErrorCause `json:"reason"`
+}
+
+// How many shards took part in a search.
+type SearchShards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	// Details of the failed shards.
+	Failures []ShardFailure `json:"failures,omitempty"`
+}
+
+// Result of a search request.
+type SearchResponse struct {
+	Took     int  `json:"took"`
+	TimedOut bool `json:"timed_out"`
+	// If Shards.Failed isn't zero the results are incomplete.
+	Shards SearchShards `json:"_shards"`
+	// Total number of matching documents, which may be more than
+	// len(Hits).
+	Total int64 `json:"-"`
+	// "eq" if Total is exact, or "gte" if the server stopped counting
+	// and Total is a lower bound.  See WithTrackTotalHits.
+	TotalRelation string          `json:"-"`
+	Hits          []Hit           `json:"-"`
+	Aggregations  json.RawMessage `json:"aggregations"`
+	// Set when the search was started as a scroll.
+	ScrollId string `json:"_scroll_id,omitempty"`
+	// Set when the search used a point in time.  It may differ
+	// from the one passed in and should be used for the next page.
+	PitId string `json:"pit_id,omitempty"`
+	// Set for a failed search within a MultiSearch.
+	Error *ESError `json:"-"`
+}
+
+// Whether some shards failed, so the results may be incomplete.
+func (sr *SearchResponse) Partial() bool {
+	return sr.Shards.Failed > 0
+}
+
+// Hit count, which newer servers report as {"value": n, ...} and
+// older ones as a plain number, which is always exact.
+type hitsTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+func (t *hitsTotal) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*t = hitsTotal{Value: n, Relation: "eq"}
+		return nil
+	}
+
+	type plain hitsTotal
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+func (sr *SearchResponse) UnmarshalJSON(data []byte) error {
+	type plain SearchResponse
+	aux := struct {
+		*plain
+		Hits struct {
+			Total hitsTotal `json:"total"`
+			Hits  []Hit     `json:"hits"`
+		} `json:"hits"`
+	}{plain: (*plain)(sr)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	sr.Total = aux.Hits.Total.Value
+	sr.TotalRelation = aux.Hits.Total.Relation
+	sr.Hits = aux.Hits.Hits
+	return nil
+}
+
+// One search within a MultiSearch.
+type SearchRequest struct {
+	// Index to search, or empty for all.
+	Index string
+	// Request body, as for Search.
+	Body interface{}
+}
+
+// Run several searches in one request.
+//
+// The responses are in the same order as reqs.  A search that failed
+// on its own gets a response with Error set rather than failing the
+// whole call.
+func (es *ElasticSearch) MultiSearch(reqs []SearchRequest) ([]*SearchResponse, error) {
+	buf := &bytes.Buffer{}
+	e := json.NewEncoder(buf)
+	for _, req := range reqs {
+		header := map[string]string{}
+		if req.Index != "" {
+			header["index"] = req.Index
+		}
+		if err := e.Encode(header); err != nil {
+			return nil, err
+		}
+		body := req.Body
+		if body == nil {
+			body = map[string]interface{}{}
+		}
+		if err := e.Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	var raw struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	_, err := es.callRaw(context.Background(), "POST",
+		es.url("_msearch").String(), buf.Bytes(), &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := make([]*SearchResponse, len(raw.Responses))
+	for i, data := range raw.Responses {
+		sr := &SearchResponse{}
+		if err := json.Unmarshal(data, sr); err != nil {
+			return nil, err
+		}
+
+		var failure struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(data, &failure); err != nil {
+			return nil, err
+		}
+		if len(failure.Error) > 0 {
+			sr.Error = &ESError{StatusCode: failure.Status}
+			sr.Error.parse(failure.Error)
+		}
+
+		rv[i] = sr
+	}
+
+	return rv, nil
+}
+
+// Run a query against an index.
+//
+// index may be empty to search all indices, and query is marshaled
+// as the request body.
+func (es *ElasticSearch) Search(index string, query interface{},
+	params map[string]string) (*SearchResponse, error) {
+
+	u := es.url(index, "_search")
+	if index == "" {
+		u = es.url("_search")
+	}
+	updateUrlQuery(u, params)
+
+	rv := &SearchResponse{}
+	_, err := es.call("POST", u.String(), query, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// Poll every interval until the document with the given id shows up
+// in searches of index, or ctx is done.  Unlike Get, which sees a
+// write straight away, this waits for the index to be refreshed.
+func (es *ElasticSearch) WaitForDoc(ctx context.Context, index, id string,
+	interval time.Duration) error {
+
+	if interval <= 0 {
+		return fmt.Errorf("Poll interval must be positive, not %v", interval)
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"ids": map[string]interface{}{"values": []string{id}},
+		},
+	}
+	u := es.url(index, "_search").String()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rv := &SearchResponse{}
+		if _, err := es.callContext(ctx, "POST", u, query, rv); err != nil {
+			return err
+		}
+		if rv.Total > 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("Gave up waiting for document %s/%s: %w",
+				index, id, ctx.Err())
+		}
+	}
+}
+
+// Run aggregations over every document in an index and return just
+// the "aggregations" object.
+//
+// aggs is marshaled as the request's "aggs" field.  No hits are
+// fetched.
+func (es *ElasticSearch) Aggregate(index string, aggs interface{}) (json.RawMessage, error) {
+	query := map[string]interface{}{
+		"size": 0,
+		"aggs": aggs,
+	}
+	rv, err := es.Search(index, query,
+		map[string]string{"filter_path": "aggregations"})
+	if err != nil {
+		return nil, err
+	}
+	return rv.Aggregations, nil
+}
+
+// Count the documents in an index matching a query.
+//
+// A nil query counts every document in the index.
+func (es *ElasticSearch) Count(index string, query interface{},
+	params map[string]string) (int64, error) {
+
+	u := es.url(index, "_count")
+	if index == "" {
+		u = es.url("_count")
+	}
+	updateUrlQuery(u, params)
+
+	rv := struct {
+		Count int64 `json:"count"`
+	}{}
+	_, err := es.call("POST", u.String(), query, &rv)
+	if err != nil {
+		return 0, err
+	}
+
+	return rv.Count, nil
+}
+
+// Add source filtering to the params for Get or Search, so the
+// returned source holds only the given fields.
+//
+// params may be nil; the (possibly new) map is returned.
+func WithSourceIncludes(params map[string]string, fields ...string) map[string]string {
+	return withParam(params, "_source_includes", strings.Join(fields, ","))
+}
+
+// Like WithSourceIncludes, but leave the given fields out instead.
+func WithSourceExcludes(params map[string]string, fields ...string) map[string]string {
+	return withParam(params, "_source_excludes", strings.Join(fields, ","))
+}
+
+// Add the params for Search to return the given stored fields in each
+// hit's Fields.  Pass "_none_" alone to skip fetching _source too.
+//
+// params may be nil; the (possibly new) map is returned.
+func WithStoredFields(params map[string]string, fields ...string) map[string]string {
+	return withParam(params, "stored_fields", strings.Join(fields, ","))
+}
+
+// Like WithStoredFields, but read the fields from doc values, which
+// is cheaper for keyword, numeric and date fields.  Combine with
+// WithStoredFields(params, "_none_") to leave out _source.
+func WithDocvalueFields(params map[string]string, fields ...string) map[string]string {
+	return withParam(params, "docvalue_fields", strings.Join(fields, ","))
+}
+
+// Add the params for Search to return each hit's sequence number and
+// primary term, for conditional updates.
+//
+// params may be nil; the (possibly new) map is returned.
+func WithSeqNoPrimaryTerm(params map[string]string) map[string]string {
+	return withParam(params, "seq_no_primary_term", "true")
+}
+
+// Add the params for Search to count every matching document, or with
+// track false not to count at all.  By default newer servers stop
+// counting at 10,000 and report TotalRelation "gte".
+//
+// params may be nil; the (possibly new) map is returned.
+func WithTrackTotalHits(params map[string]string, track bool) map[string]string {
+	return withParam(params, "track_total_hits", strconv.FormatBool(track))
+}
+
+// Like WithTrackTotalHits, but count accurately only up to n.
+func WithTrackTotalHitsUpTo(params map[string]string, n int) map[string]string {
+	return withParam(params, "track_total_hits", strconv.Itoa(n))
+}
+
+func withParam(params map[string]string, key, value string) map[string]string {
+	if params == nil {
+		params = map[string]string{}
+	}
+	params[key] = value
+	return params
+}
@@ -0,0 +1,84 @@
+package elasticsearch
+
+import (
+	"strings"
+	"time"
+)
+
+// Default time between sniffs of the cluster's nodes.
+const DefaultSniffInterval = 5 * time.Minute
+
+type nodesHttpResponse struct {
+	Nodes map[string]struct {
+		Http struct {
+			PublishAddress string `json:"publish_address"`
+		} `json:"http"`
+	} `json:"nodes"`
+}
+
+func (es *ElasticSearch) startSniffing() {
+	stop := make(chan struct{})
+	es.sniffStop = stop
+
+	interval := es.SniffInterval
+	if interval == 0 {
+		interval = DefaultSniffInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := es.sniff(); err != nil {
+				es.logf("Error sniffing nodes: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the background sniffer, if it is running.  This is safe to
+// call more than once, and concurrently.
+func (es *ElasticSearch) StopSniffing() {
+	// Waits for a sniffer that's starting, or keeps one from starting.
+	es.sniffOnce.Do(func() {})
+	es.sniffStopOnce.Do(func() {
+		if es.sniffStop != nil {
+			close(es.sniffStop)
+		}
+	})
+}
+
+// Refresh the host pool from the cluster's node list.
+//
+// Errors and empty results leave the current hosts in place.
+func (es *ElasticSearch) sniff() error {
+	nodes := &nodesHttpResponse{}
+	_, err := es.call("GET", es.url("_nodes", "http").String(), nil, nodes)
+	if err != nil {
+		return err
+	}
+
+	var hosts []poolHost
+	for _, node := range nodes.Nodes {
+		addr := node.Http.PublishAddress
+		// Addresses may be reported as "hostname/ip:port".
+		if i := strings.LastIndex(addr, "/"); i >= 0 {
+			addr = addr[i+1:]
+		}
+		if addr != "" {
+			hosts = append(hosts, poolHost{scheme: es.scheme, host: addr})
+		}
+	}
+
+	if len(hosts) > 0 {
+		es.hosts.replace(hosts)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Progress counters of a by-query or reindex task.
+type TaskStatus struct {
+	Total            int64 `json:"total"`
+	Created          int64 `json:"created"`
+	Updated          int64 `json:"updated"`
+	Deleted          int64 `json:"deleted"`
+	Noops            int64 `json:"noops"`
+	Batches          int64 `json:"batches"`
+	VersionConflicts int64 `json:"version_conflicts"`
+}
+
+// State of a background task.
+type TaskInfo struct {
+	Completed   bool
+	Action      string
+	Description string
+	Status      TaskStatus
+	RunningTime time.Duration
+	// The operation's final response once Completed, e.g. a
+	// ByQueryResponse.
+	Response json.RawMessage
+	// Set if the task failed.
+	Error json.RawMessage
+}
+
+func (ti *TaskInfo) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Action             string     `json:"action"`
+			Description        string     `json:"description"`
+			Status             TaskStatus `json:"status"`
+			RunningTimeInNanos int64      `json:"running_time_in_nanos"`
+		} `json:"task"`
+		Response json.RawMessage `json:"response"`
+		Error    json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*ti = TaskInfo{
+		Completed:   aux.Completed,
+		Action:      aux.Task.Action,
+		Description: aux.Task.Description,
+		Status:      aux.Task.Status,
+		RunningTime: time.Duration(aux.Task.RunningTimeInNanos),
+		Response:    aux.Response,
+		Error:       aux.Error,
+	}
+	return nil
+}
+
+// Fetch the state of a background task by its ID.
+func (es *ElasticSearch) GetTask(taskID string) (*TaskInfo, error) {
+	return es.getTask(context.Background(), taskID)
+}
+
+func (es *ElasticSearch) getTask(ctx context.Context, taskID string) (*TaskInfo, error) {
+	rv := &TaskInfo{}
+	_, err := es.callContext(ctx, "GET", es.url("_tasks", taskID).String(), nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Poll a task every pollInterval until it completes or ctx is done.
+func (es *ElasticSearch) WaitForTask(ctx context.Context, taskID string,
+	pollInterval time.Duration) (*TaskInfo, error) {
+
+	if pollInterval <= 0 {
+		return nil, fmt.Errorf("Poll interval must be positive, not %v",
+			pollInterval)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := es.getTask(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		if info.Completed {
+			return info, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return info, fmt.Errorf("Gave up waiting for task %s: %w",
+				taskID, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"strconv"
+)
+
+// Body of a single-document update.  Set either Doc or Script.
+type UpdateBody struct {
+	// Fields to merge into the document.
+	Doc map[string]interface{} `json:"doc,omitempty"`
+	// Script to run against the document instead.
+	Script *Script `json:"script,omitempty"`
+	// Document to index if none exists yet.
+	Upsert map[string]interface{} `json:"upsert,omitempty"`
+	// Index Doc itself if no document exists yet.
+	DocAsUpsert bool `json:"doc_as_upsert,omitempty"`
+}
+
+// Option for Update.
+type UpdateOption func(params map[string]string)
+
+// Retry the update up to n times if the document changes between
+// being read and written.
+func RetryOnConflict(n int) UpdateOption {
+	return func(params map[string]string) {
+		params["retry_on_conflict"] = strconv.Itoa(n)
+	}
+}
+
+// Result of updating a single document.
+type UpdateResponse struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type"`
+	Id    string `json:"_id"`
+	// "updated", "created" for an upsert, or "noop" if the
+	// document was left unchanged.
+	Result      string `json:"result"`
+	Version     int64  `json:"_version"`
+	SeqNo       int64  `json:"_seq_no"`
+	PrimaryTerm int64  `json:"_primary_term"`
+}
+
+// Noop reports whether the update left the document unchanged.
+func (r *UpdateResponse) Noop() bool {
+	return r.Result == "noop"
+}
+
+// Change part of an existing document.
+//
+// An empty doctype uses the typeless endpoint of 7.0 and later.
+func (es *ElasticSearch) Update(index, doctype, id string, body UpdateBody,
+	opts ...UpdateOption) (*UpdateResponse, error) {
+
+	params := map[string]string{}
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	u := es.url(index, doctype, id, "_update")
+	if doctype == "" {
+		u = es.url(index, "_update", id)
+	}
+	updateUrlQuery(u, params)
+
+	rv := &UpdateResponse{}
+	_, err := es.call("POST", u.String(), body, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
@@ -0,0 +1,64 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Record the deprecation warnings in a response's Warning headers.
+//
+// Each distinct warning is logged the first time it is seen.
+func (es *ElasticSearch) noteWarnings(resp *http.Response) {
+	for _, header := range resp.Header.Values("Warning") {
+		msg := warningText(header)
+
+		es.warnMu.Lock()
+		seen := es.warnSeen[msg]
+		if !seen {
+			if es.warnSeen == nil {
+				es.warnSeen = map[string]bool{}
+			}
+			es.warnSeen[msg] = true
+			es.warnings = append(es.warnings, msg)
+		}
+		es.warnMu.Unlock()
+
+		if !seen {
+			es.logf("Server warning: %s", msg)
+		}
+	}
+}
+
+// The quoted text of a Warning header, which looks like
+// `299 Elasticsearch-7.10.0 "text" "date"`.  Anything unexpected is
+// returned as a whole.
+func warningText(header string) string {
+	start := strings.IndexByte(header, '"')
+	if start < 0 {
+		return header
+	}
+
+	var sb strings.Builder
+	for i := start + 1; i < len(header); i++ {
+		switch c := header[i]; c {
+		case '\\':
+			if i+1 < len(header) {
+				i++
+				sb.WriteByte(header[i])
+			}
+		case '"':
+			return sb.String()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return header
+}
+
+// Distinct warnings the server has sent, such as deprecation
+// notices, in the order first seen.
+func (es *ElasticSearch) Warnings() []string {
+	es.warnMu.Lock()
+	defer es.warnMu.Unlock()
+	return append([]string(nil), es.warnings...)
+}